@@ -0,0 +1,116 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+// Advice that resets the advice kept by MadviseRange, by the advice it resets
+var adviceReset = map[int]int{
+	MADV_NORMAL:      MADV_NORMAL,
+	MADV_RANDOM:      MADV_NORMAL,
+	MADV_SEQUENTIAL:  MADV_NORMAL,
+	MADV_DONTFORK:    MADV_DOFORK,
+	MADV_DOFORK:      MADV_DOFORK,
+	MADV_MERGEABLE:   MADV_UNMERGEABLE,
+	MADV_UNMERGEABLE: MADV_UNMERGEABLE,
+	MADV_HUGEPAGE:    MADV_NOHUGEPAGE,
+	MADV_NOHUGEPAGE:  MADV_NOHUGEPAGE,
+	MADV_DONTDUMP:    MADV_DODUMP,
+	MADV_DODUMP:      MADV_DODUMP,
+	MADV_WIPEONFORK:  MADV_KEEPONFORK,
+	MADV_KEEPONFORK:  MADV_KEEPONFORK,
+}
+
+// MadviseRange advises the kernel about the expected behavior of the pages holding n bytes of the
+// mapping starting at file offset off. The range is widened to whole pages, MADV_DONTNEED, MADV_FREE
+// and MADV_REMOVE only apply to the pages entirely inside it. Advice that changes the behavior of the
+// pages, like MADV_RANDOM, is applied again when the mapping grows or moves.
+// Windowed mappings are not supported.
+func (m *Mmap) MadviseRange(off, n int64, advice int) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return m.wrap("madvise", off, n, ErrClosed)
+	}
+	if m.window > 0 {
+		return m.wrap("madvise", off, n, ErrUnsupported)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end {
+		return m.wrap("madvise", off, n, ErrOutOfRange)
+	}
+	from := off &^ (pageSize - 1)
+	to := (off + n + pageSize - 1) &^ (pageSize - 1)
+	switch advice {
+	case MADV_DONTNEED, MADV_FREE, MADV_REMOVE:
+		if off > start {
+			from = (off + pageSize - 1) &^ (pageSize - 1)
+		}
+		if off+n < end {
+			// The rest of the last page past the end of data holds nothing to keep
+			to = (off + n) &^ (pageSize - 1)
+		}
+	}
+	if n == 0 || from >= to {
+		return nil
+	}
+	err := madvise(m.pages(from, to), advice)
+	if err != nil {
+		return m.wrap("madvise", off, n, err)
+	}
+	reset, ok := adviceReset[advice]
+	if !ok {
+		return nil
+	}
+	if m.advised == nil {
+		m.advised = make(map[int][]rangeAttr)
+	}
+	// Resetting advice is kept as well, advice given to the whole mapping is applied first when it moves
+	m.advised[reset] = setRange(m.advised[reset], from, to, advice, true)
+	return nil
+}
+
+// Apply the advice kept by MadviseRange again after the mapping was mapped or grown,
+// dropping the ranges that are not mapped any more. It runs after the advice given to
+// the whole mapping, so ranged advice overrides it.
+func (m *Mmap) readvise() error {
+	if m.advised == nil {
+		return nil
+	}
+	advised := make(map[int][]rangeAttr, len(m.advised))
+	for reset, set := range m.advised {
+		advised[reset] = m.clipRanges(set)
+	}
+	m.advised = advised
+	for _, set := range advised {
+		for _, r := range set {
+			err := madvise(m.pages(r.Off, r.Off+r.Len), r.attr)
+			if err != nil {
+				return m.wrap("madvise", r.Off, r.Len, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Reset the advice kept by MadviseRange on the whole mapping, mremap fails on mappings split by madvise.
+// There is no advice restoring the default huge page behavior, so mappings given MADV_HUGEPAGE or
+// MADV_NOHUGEPAGE ranges are advised MADV_NOHUGEPAGE as a whole, unless WithHugePages was given.
+func (m *Mmap) unadvise() error {
+	if m.mem == nil {
+		return nil
+	}
+	for reset, set := range m.advised {
+		if len(set) == 0 {
+			continue
+		}
+		err := madvise(m.mem, reset)
+		if err != nil {
+			return m.wrap("madvise", m.start&^(pageSize-1), int64(len(m.mem)), err)
+		}
+	}
+	return nil
+}
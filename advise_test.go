@@ -0,0 +1,135 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// Return the VmFlags of the memory area holding b[0] from /proc/self/smaps
+func vmFlags(t *testing.T, b []byte) string {
+	addr := uint64(uintptr(unsafe.Pointer(&b[0])))
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	var found bool
+	for s.Scan() {
+		line := s.Text()
+		if flags, ok := strings.CutPrefix(line, "VmFlags:"); ok && found {
+			return " " + strings.TrimSpace(flags) + " "
+		}
+		lo, hi, ok := strings.Cut(strings.Fields(line)[0], "-")
+		if !ok {
+			continue
+		}
+		from, err1 := strconv.ParseUint(lo, 16, 64)
+		to, err2 := strconv.ParseUint(hi, 16, 64)
+		if err1 == nil && err2 == nil {
+			found = addr >= from && addr < to
+		}
+	}
+	t.Fatal("memory area not found")
+	return ""
+}
+
+func TestMadviseRange(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name := tmpname()
+	m, err := Create(name, 4*page, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	err = m.MadviseRange(10, 10, MADV_RANDOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.MadviseRange(2*page+1, page, MADV_SEQUENTIAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.MadviseRange(page, 4*page, MADV_SEQUENTIAL)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to advise past the end of the mapping", err)
+	}
+	// Grow the mapping so it is moved by mremap
+	_, err = m.WriteAt(rndmessage(100), 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(vmFlags(t, m.Data[page-1:]), " rr ") {
+		t.Error("random advice not applied again after growing")
+	}
+	for _, off := range []int64{2 * page, 4*page - 1} {
+		if !strings.Contains(vmFlags(t, m.Data[off:]), " sr ") {
+			t.Error("sequential advice not applied again after growing")
+		}
+	}
+	flags := vmFlags(t, m.Data[page:])
+	if strings.Contains(flags, " rr ") || strings.Contains(flags, " sr ") {
+		t.Error("advice applied outside the advised ranges", flags)
+	}
+	// Private mappings move to anonymous memory when they grow
+	name, err = rndfile(int(4 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	p, err := OpenPrivate(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.MadviseRange(page, page, MADV_RANDOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.WriteAt(rndmessage(100), 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(vmFlags(t, p.Data[page:]), " rr ") {
+		t.Error("advice of private mapping not applied again after growing")
+	}
+	if strings.Contains(vmFlags(t, p.Data), " rr ") {
+		t.Error("advice of private mapping applied outside the advised range")
+	}
+}
+
+func TestMadviseRangeDiscard(t *testing.T) {
+	page := int64(os.Getpagesize())
+	m, err := NewAnonymous(4 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	_, err = m.WriteAt(bytes.Repeat([]byte{'x'}, int(m.Size())), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.MadviseRange(10, 3*page, MADV_DONTNEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []byte{'x', 0, 0, 'x'} {
+		if m.Data[int64(i)*page] != want || m.Data[int64(i+1)*page-1] != want {
+			t.Error("wrong pages discarded, page", i)
+		}
+	}
+}
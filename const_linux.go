@@ -18,11 +18,14 @@ const (
 	PROT_WRITE = 0x2 // page protection: read-write
 	PROT_EXEC  = 0x4 // page protection: read-execute
 
-	MAP_SHARED          = 0x1    // share changes
-	MAP_PRIVATE         = 0x2    // changes are private
-	MAP_SHARED_VALIDATE = 0x3    // share changes, but validate
-	MAP_LOCKED          = 0x2000 // pages are locked to RAM
-	MAP_POPULATE        = 0x8000 // populate (prefault) pagetables
+	MAP_SHARED          = 0x1                  // share changes
+	MAP_PRIVATE         = 0x2                  // changes are private
+	MAP_SHARED_VALIDATE = 0x3                  // share changes, but validate
+	MAP_HUGE_SHIFT      = 26                   // shift of the log2 of the hugetlb page size in the flags
+	MAP_HUGE_2MB        = 21 << MAP_HUGE_SHIFT // use 2MiB hugetlb pages
+	MAP_HUGE_1GB        = 30 << MAP_HUGE_SHIFT // use 1GiB hugetlb pages
+
+	MLOCK_ONFAULT = 0x1 // lock pages as they are faulted in
 
 	MREMAP_MAYMOVE   = 0x1 // may move the mapping
 	MREMAP_FIXED     = 0x2 // map at a fixed address
@@ -33,25 +36,52 @@ const (
 	SEEK_END = 0x2 // seek relative to the end
 
 	// Mapping advice, refer to madvise(2) manual page.
-	MADV_NORMAL      = 0x0  // no special treatment.  This is the default.
-	MADV_RANDOM      = 0x1  // expect random page references.
-	MADV_SEQUENTIAL  = 0x2  // expect sequential page references.
-	MADV_WILLNEED    = 0x3  // will need these pages.
-	MADV_DONTNEED    = 0x4  // don't need these pages.
-	MADV_FREE        = 0x8  // pages can be freed.
-	MADV_REMOVE      = 0x9  // remove these pages from the mappings.
-	MADV_DONTFORK    = 0xa  // do not inherit across fork.
-	MADV_DOFORK      = 0xb  // inherit across fork.
-	MADV_MERGEABLE   = 0xc  // enable Kernel Samepage Merging (KSM) for the pages
-	MADV_UNMERGEABLE = 0xd  // disable Kernel Samepage Merging (KSM) for the pages
-	MADV_HUGEPAGE    = 0xe  // mark page for huge page support
-	MADV_NOHUGEPAGE  = 0xf  // mark page for no huge page support
-	MADV_DONTDUMP    = 0x10 // do not include in the core dump.
-	MADV_DODUMP      = 0x11 // include in the core dump.
-	MADV_WIPEONFORK  = 0x12 // discard contents on fork
-	MADV_KEEPONFORK  = 0x13 // keep contents on fork
-	MADV_COLD        = 0x14 // page is cold (not accessed in last hour).
-	MADV_PAGEOUT     = 0x15 // page is being paged out.
-
-	MS_SYNC = 0x4
+	MADV_NORMAL         = 0x0  // no special treatment.  This is the default.
+	MADV_RANDOM         = 0x1  // expect random page references.
+	MADV_SEQUENTIAL     = 0x2  // expect sequential page references.
+	MADV_WILLNEED       = 0x3  // will need these pages.
+	MADV_DONTNEED       = 0x4  // don't need these pages.
+	MADV_FREE           = 0x8  // pages can be freed.
+	MADV_REMOVE         = 0x9  // remove these pages from the mappings.
+	MADV_DONTFORK       = 0xa  // do not inherit across fork.
+	MADV_DOFORK         = 0xb  // inherit across fork.
+	MADV_MERGEABLE      = 0xc  // enable Kernel Samepage Merging (KSM) for the pages
+	MADV_UNMERGEABLE    = 0xd  // disable Kernel Samepage Merging (KSM) for the pages
+	MADV_HUGEPAGE       = 0xe  // mark page for huge page support
+	MADV_NOHUGEPAGE     = 0xf  // mark page for no huge page support
+	MADV_DONTDUMP       = 0x10 // do not include in the core dump.
+	MADV_DODUMP         = 0x11 // include in the core dump.
+	MADV_WIPEONFORK     = 0x12 // discard contents on fork
+	MADV_KEEPONFORK     = 0x13 // keep contents on fork
+	MADV_COLD           = 0x14 // page is cold (not accessed in last hour).
+	MADV_PAGEOUT        = 0x15 // page is being paged out.
+	MADV_POPULATE_READ  = 0x16 // populate the page tables, faulting in the pages readable.
+	MADV_POPULATE_WRITE = 0x17 // populate the page tables, faulting in the pages writable.
+
+	// Flush modes, refer to msync(2) manual page.
+	MS_ASYNC      = 0x1 // schedule the write back and return immediately.
+	MS_INVALIDATE = 0x2 // invalidate other mappings of the same file.
+	MS_SYNC       = 0x4 // wait for the write back to complete.
+
+	// Splice flags, refer to splice(2) manual page.
+	SPLICE_F_MOVE     = 0x1 // move pages instead of copying.
+	SPLICE_F_NONBLOCK = 0x2 // do not block on the pipe.
+	SPLICE_F_MORE     = 0x4 // more data will be coming in a subsequent splice.
+	SPLICE_F_GIFT     = 0x8 // pages passed in are a gift.
+
+	// Memory file flags, refer to memfd_create(2) manual page.
+	MFD_CLOEXEC       = 0x1 // close the file descriptor on exec.
+	MFD_ALLOW_SEALING = 0x2 // allow sealing operations on the file.
+	MFD_HUGETLB       = 0x4 // back the file with hugetlb pages, sized like MAP_HUGE_* flags.
+
+	// File descriptor commands and seals, refer to fcntl(2) manual page.
+	F_GETFL             = 0x3   // get the access mode and status flags of the file.
+	F_DUPFD_CLOEXEC     = 0x406 // duplicate the file descriptor with the close-on-exec flag set.
+	F_ADD_SEALS         = 0x409 // add seals to the file.
+	F_GET_SEALS         = 0x40a // get the seals of the file.
+	F_SEAL_SEAL         = 0x1   // prevent further seals from being set.
+	F_SEAL_SHRINK       = 0x2   // prevent the file from shrinking.
+	F_SEAL_GROW         = 0x4   // prevent the file from growing.
+	F_SEAL_WRITE        = 0x8   // prevent writes, fails while writable shared mappings exist.
+	F_SEAL_FUTURE_WRITE = 0x10  // prevent new writable mappings and writes, existing mappings are kept.
 )
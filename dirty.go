@@ -0,0 +1,138 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import "sort"
+
+// Range is a span of Len bytes starting at file offset Off.
+type Range struct {
+	Off int64
+	Len int64
+}
+
+// A page aligned range of the mapping with an attribute, like its protection
+type rangeAttr struct {
+	Range
+	attr int
+}
+
+// MarkDirty records that n bytes starting at file offset off were changed through Data,
+// so the next Sync flushes them. Write and WriteAt record their changes on their own.
+func (m *Mmap) MarkDirty(off, n int64) error {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return m.wrap("markdirty", off, n, ErrClosed)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end {
+		return m.wrap("markdirty", off, n, ErrOutOfRange)
+	}
+	m.markDirty(off, n)
+	return nil
+}
+
+// Dirty returns the page aligned ranges changed since the last Sync, sorted by offset.
+func (m *Mmap) Dirty() []Range {
+	m.dirtyMu.Lock()
+	dirty := append([]Range(nil), m.dirty...)
+	m.dirtyMu.Unlock()
+	return dirty
+}
+
+// Add the pages holding n bytes at file offset off to the dirty set
+func (m *Mmap) markDirty(off, n int64) {
+	if n <= 0 || m.private {
+		return
+	}
+	from := off &^ (pageSize - 1)
+	to := (off + n + pageSize - 1) &^ (pageSize - 1)
+	m.dirtyMu.Lock()
+	m.dirty = addRange(m.dirty, from, to)
+	m.dirtyMu.Unlock()
+}
+
+// Take the dirty set, leaving it empty
+func (m *Mmap) takeDirty() []Range {
+	m.dirtyMu.Lock()
+	dirty := m.dirty
+	m.dirty = nil
+	m.dirtyMu.Unlock()
+	return dirty
+}
+
+// Put back ranges that failed to sync
+func (m *Mmap) restoreDirty(dirty []Range) {
+	m.dirtyMu.Lock()
+	for _, r := range dirty {
+		m.dirty = addRange(m.dirty, r.Off, r.Off+r.Len)
+	}
+	m.dirtyMu.Unlock()
+}
+
+// Add the span [from, to) to a sorted set of ranges, coalescing overlapping and adjacent ranges
+func addRange(set []Range, from, to int64) []Range {
+	i := sort.Search(len(set), func(i int) bool {
+		return set[i].Off+set[i].Len >= from
+	})
+	j := i
+	for ; j < len(set) && set[j].Off <= to; j++ {
+		if set[j].Off < from {
+			from = set[j].Off
+		}
+		if end := set[j].Off + set[j].Len; end > to {
+			to = end
+		}
+	}
+	return append(set[:i], append([]Range{{Off: from, Len: to - from}}, set[j:]...)...)
+}
+
+// Set the attribute of the span [from, to) in a sorted set of ranges, or remove the span from the set when keep is false
+func setRange(set []rangeAttr, from, to int64, attr int, keep bool) []rangeAttr {
+	var out []rangeAttr
+	for _, r := range set {
+		if r.Off+r.Len <= from || r.Off >= to {
+			out = append(out, r)
+			continue
+		}
+		if r.Off < from {
+			out = append(out, rangeAttr{Range{Off: r.Off, Len: from - r.Off}, r.attr})
+		}
+		if end := r.Off + r.Len; end > to {
+			out = append(out, rangeAttr{Range{Off: to, Len: end - to}, r.attr})
+		}
+	}
+	if keep {
+		out = append(out, rangeAttr{Range{Off: from, Len: to - from}, attr})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Off < out[j].Off
+	})
+	return out
+}
+
+// Clip a set of ranges to the pages of the mapping, dropping the ranges that are not mapped.
+// The set is copied, so it can be restored when mapping fails.
+func (m *Mmap) clipRanges(set []rangeAttr) []rangeAttr {
+	base := m.start &^ (pageSize - 1)
+	end := base + (int64(len(m.mem))+pageSize-1)&^(pageSize-1)
+	var out []rangeAttr
+	for _, r := range set {
+		from, to := r.Off, r.Off+r.Len
+		if from < base {
+			from = base
+		}
+		if to > end {
+			to = end
+		}
+		if from < to {
+			out = append(out, rangeAttr{Range{Off: from, Len: to - from}, r.attr})
+		}
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAddRange(t *testing.T) {
+	var set []Range
+	set = addRange(set, 10, 20)
+	set = addRange(set, 40, 50)
+	set = addRange(set, 0, 5)
+	if len(set) != 3 || set[0].Off != 0 || set[1].Off != 10 || set[2].Off != 40 {
+		t.Fatal("wrong order of ranges", set)
+	}
+	set = addRange(set, 20, 40)
+	if len(set) != 2 || set[1] != (Range{Off: 10, Len: 40}) {
+		t.Fatal("adjacent ranges not coalesced", set)
+	}
+	set = addRange(set, 3, 12)
+	if len(set) != 1 || set[0] != (Range{Off: 0, Len: 50}) {
+		t.Fatal("overlapping ranges not coalesced", set)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name := tmpname()
+	m, err := Create(name, 8*page, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	if len(m.Dirty()) != 0 {
+		t.Error("new mapping has dirty pages")
+	}
+	msg := rndmessage(10)
+	_, err = m.WriteAt(msg, page+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.WriteAt(msg, 2*page-5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(m.Data[6*page:], msg)
+	err = m.MarkDirty(6*page, int64(len(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirty := m.Dirty()
+	if len(dirty) != 2 || dirty[0] != (Range{Off: page, Len: 2 * page}) || dirty[1] != (Range{Off: 6 * page, Len: page}) {
+		t.Error("wrong dirty ranges", dirty)
+	}
+	err = m.MarkDirty(8*page, 1)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to mark pages outside of the mapping as dirty")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Dirty()) != 0 {
+		t.Error("dirty pages left after sync")
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[6*page:6*page+int64(len(msg))], msg) {
+		t.Error("wrong data after syncing pages marked dirty")
+	}
+}
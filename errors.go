@@ -0,0 +1,62 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+// Errors returned by the methods of Mmap, wrapped in *Error.
+var (
+	ErrInvalidWhence    = errors.New("invalid whence value")
+	ErrNegativePosition = errors.New("negative position")
+	ErrAppendWriteAt    = errors.New("invalid use of WriteAt on file opened with O_APPEND")
+	ErrOutOfRange       = errors.New("access outside of the mapped range")
+	ErrInvalidRange     = errors.New("invalid mapping range")
+	ErrTooLarge         = errors.New("requested size bigger than arch maxSize")
+	ErrUnsupported      = errors.New("operation not supported by the mapping")
+	ErrReadOnly         = errors.New("mapping is read-only")
+	ErrWriteOnly        = errors.New("write-only files can not be mapped")
+	ErrClosed           = os.ErrClosed
+)
+
+// Error records an error and the operation, file and byte range that caused it.
+// It follows the conventions of *fs.PathError and unwraps to the underlying error,
+// so errors.Is(err, syscall.ENOMEM) works for failed system calls.
+type Error struct {
+	Op   string
+	Path string
+	Off  int64 // file offset of the range, if any
+	Len  int64 // length of the range, 0 when the error is not about a range
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Len > 0 {
+		return e.Op + " " + e.Path + " [" + strconv.FormatInt(e.Off, 10) + ":" +
+			strconv.FormatInt(e.Off+e.Len, 10) + "]: " + e.Err.Error()
+	}
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether this error represents a timeout.
+func (e *Error) Timeout() bool {
+	t, ok := e.Err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// Wrap an error of the mapping with the operation and range that caused it
+func (m *Mmap) wrap(op string, off, length int64, err error) error {
+	return &Error{Op: op, Path: m.Name(), Off: off, Len: length, Err: err}
+}
@@ -0,0 +1,54 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	name, err := rndfile(os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	err = m.Truncate(int64(2 * os.Getpagesize()))
+	var merr *Error
+	if !errors.As(err, &merr) {
+		t.Fatal("syscall error is not an *Error", err)
+	}
+	if merr.Op != "ftruncate" || merr.Path != name {
+		t.Error("wrong operation or path in error", merr)
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		t.Error("errno not preserved in error", err)
+	}
+	_, err = m.Seek(0, 42)
+	if !errors.Is(err, ErrInvalidWhence) {
+		t.Error("wrong error for invalid whence", err)
+	}
+	err = m.Remap(-1, 10)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("wrong error for invalid range", err)
+	}
+	big := tmpname()
+	defer os.Remove(big)
+	_, err = Create(big, maxSize+1, os.O_RDWR|os.O_CREATE, 0644)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Error("wrong error for oversized mapping", err)
+	}
+}
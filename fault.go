@@ -0,0 +1,160 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"unsafe"
+)
+
+// ErrFault matches any *FaultError with errors.Is.
+var ErrFault = errors.New("memory fault")
+
+// FaultError records a memory fault (SIGBUS or SIGSEGV) while accessing the mapping,
+// usually because the file was truncated underneath it by another process.
+// The bytes before Off were transferred, so the access can be resumed from there.
+type FaultError struct {
+	Path string
+	Off  int64 // file offset of the faulting access
+	Err  error // runtime error of the fault
+}
+
+func (e *FaultError) Error() string {
+	return "fault " + e.Path + " at offset " + strconv.FormatInt(e.Off, 10) + ": " + e.Err.Error()
+}
+
+func (e *FaultError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrFault.
+func (e *FaultError) Is(target error) bool {
+	return target == ErrFault
+}
+
+// A fault caught by safeCopy
+type fault struct {
+	addr uintptr // faulting address, 0 if unknown
+	err  error
+}
+
+func (f *fault) Error() string {
+	return f.err.Error()
+}
+
+// Do calls fn with Data, turning memory faults while fn accesses it into a *FaultError instead
+// of crashing the program. The mapping can not move or grow while fn runs. Changes made by fn
+// are not tracked, report them with MarkDirty after Do returns.
+func (m *Mmap) Do(fn func(b []byte) error) error {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return m.wrap("do", 0, 0, ErrClosed)
+	}
+	var ferr error
+	err := guard(func() {
+		ferr = fn(m.Data)
+	})
+	if err != nil {
+		return m.faultError(err, m.start)
+	}
+	return ferr
+}
+
+// Copy from Data at file offset off to b
+func (m *Mmap) copyOut(b []byte, off int64) (int, error) {
+	n, err := safeCopy(b, m.Data[off-m.start:])
+	return n, m.faultError(err, off+int64(n))
+}
+
+// Copy b to Data at file offset off
+func (m *Mmap) copyIn(off int64, b []byte) (int, error) {
+	n, err := safeCopy(m.Data[off-m.start:], b)
+	return n, m.faultError(err, off+int64(n))
+}
+
+// Convert a fault caught while accessing file offset off to a *FaultError,
+// preferring the exact faulting address when the runtime reports it
+func (m *Mmap) faultError(err error, off int64) error {
+	f, ok := err.(*fault)
+	if !ok {
+		return err
+	}
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(m.mem)))
+	if f.addr >= addr && f.addr < addr+uintptr(len(m.mem)) {
+		off = m.start&^(pageSize-1) + int64(f.addr-addr)
+	}
+	return &FaultError{Path: m.Name(), Off: off, Err: f.err}
+}
+
+// Safely copy data without panicking on bus errors.
+// On a fault the copy is repeated a page at a time to find how many bytes were copied.
+func safeCopy(dst, src []byte) (n int, err error) {
+	n, err = tryCopy(dst, src)
+	if err == nil {
+		return n, nil
+	}
+	n = 0
+	for n < len(dst) && n < len(src) {
+		c := chunk(dst[n:], src[n:])
+		_, err = tryCopy(dst[n:n+c], src[n:n+c])
+		if err != nil {
+			return n, err
+		}
+		n += c
+	}
+	return n, nil
+}
+
+// Copy data, recovering from faults
+func tryCopy(dst, src []byte) (n int, err error) {
+	err = guard(func() {
+		n = copy(dst, src)
+	})
+	return n, err
+}
+
+// Run fn turning memory faults into errors instead of crashing the program.
+// The previous fault setting of the goroutine is restored afterwards, other panics are propagated.
+func guard(fn func()) (err error) {
+	old := debug.SetPanicOnFault(true)
+	defer func() {
+		debug.SetPanicOnFault(old)
+		if e := recover(); e != nil {
+			a, ok := e.(interface{ Addr() uintptr })
+			if !ok {
+				panic(e)
+			}
+			f := &fault{addr: a.Addr()}
+			if f.err, _ = e.(error); f.err == nil {
+				f.err = fmt.Errorf("bus error: %v", e)
+			}
+			err = f
+		}
+	}()
+	fn()
+	return nil
+}
+
+// Length of the next copy that stays within a single page of both dst and src
+func chunk(dst, src []byte) int {
+	c := len(dst)
+	if len(src) < c {
+		c = len(src)
+	}
+	for _, b := range [][]byte{dst, src} {
+		addr := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+		if left := int(pageSize) - int(addr%uintptr(pageSize)); left < c {
+			c = left
+		}
+	}
+	return c
+}
@@ -0,0 +1,127 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime/debug"
+	"testing"
+)
+
+func TestFault(t *testing.T) {
+	page := os.Getpagesize()
+	name, err := rndfile(4 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = f.Truncate(int64(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 3*page)
+	n, err := m.ReadAt(b, 100)
+	if !errors.Is(err, ErrFault) {
+		t.Fatal("expected a fault reading truncated pages", err)
+	}
+	if n != page-100 {
+		t.Error("wrong number of bytes read before the fault", n)
+	}
+	var ferr *FaultError
+	if !errors.As(err, &ferr) {
+		t.Fatal("fault is not a *FaultError")
+	}
+	if ferr.Path != name || ferr.Off != int64(page) {
+		t.Error("wrong path or offset of fault", ferr)
+	}
+	n, err = m.ReadAt(b[:page-100], 100)
+	if err != nil || n != page-100 {
+		t.Error("failed to read the pages before the fault", err)
+	}
+}
+
+func TestPanicOnFaultRestored(t *testing.T) {
+	name, err := rndfile(os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	old := debug.SetPanicOnFault(false)
+	defer debug.SetPanicOnFault(old)
+	b := make([]byte, 10)
+	_, err = m.ReadAt(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if debug.SetPanicOnFault(false) {
+		t.Error("panic on fault setting leaked after reading")
+	}
+}
+
+func TestDo(t *testing.T) {
+	page := os.Getpagesize()
+	name, err := rndfile(2 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	errTest := errors.New("test")
+	err = m.Do(func(b []byte) error {
+		if !bytes.Equal(b, data) {
+			t.Error("wrong data passed to Do")
+		}
+		return errTest
+	})
+	if err != errTest {
+		t.Error("error of the function not returned by Do")
+	}
+	err = os.Truncate(name, int64(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	err = m.Do(func(b []byte) error {
+		for _, c := range b {
+			sum += int(c)
+		}
+		return nil
+	})
+	var ferr *FaultError
+	if !errors.As(err, &ferr) {
+		t.Fatal("expected a fault accessing truncated pages", err)
+	}
+	if ferr.Off != int64(page) {
+		t.Error("wrong offset of fault", ferr.Off)
+	}
+}
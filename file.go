@@ -0,0 +1,78 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"os"
+	"syscall"
+)
+
+// FromFile maps the open file f, configured by the given options. The access mode and status flags
+// of f decide the protection of the mapping and whether it appends, flags set with WithFlag are ignored.
+// Write-only files can not be mapped and fail with ErrWriteOnly.
+// Close leaves f open unless WithOwnedFile is given, so f must stay open until the mapping is closed.
+func FromFile(f *os.File, opts ...Option) (*Mmap, error) {
+	o, err := parseOptions(f.Name(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return mapFile(f, o)
+}
+
+// FromFd maps the file behind the descriptor fd, using name in errors and as the Name of the mapping.
+// The access mode and status flags of fd decide the protection of the mapping like they do for FromFile.
+// By default the mapping works on a duplicate of fd and fd stays open after Close, with WithOwnedFile
+// the mapping takes fd over and Close closes it.
+func FromFd(fd uintptr, name string, opts ...Option) (*Mmap, error) {
+	o, err := parseOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !o.owned {
+		// The finalizer of *os.File would close the descriptor of the caller
+		dup, err := fcntl(fd, F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return nil, &Error{Op: "fcntl", Path: name, Err: err}
+		}
+		fd = uintptr(dup)
+		o.owned = true
+	}
+	f := os.NewFile(fd, name)
+	if f == nil {
+		return nil, &Error{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+	return mapFile(f, o)
+}
+
+// Map an open file, taking the open flags from its descriptor.
+// Owned files are closed when mapping fails.
+func mapFile(f *os.File, o options) (*Mmap, error) {
+	flag, err := fcntl(f.Fd(), F_GETFL, 0)
+	if err != nil {
+		if o.owned {
+			f.Close()
+		}
+		return nil, &Error{Op: "fcntl", Path: f.Name(), Err: err}
+	}
+	if flag&syscall.O_ACCMODE == os.O_WRONLY {
+		if o.owned {
+			f.Close()
+		}
+		return nil, &Error{Op: "mmap", Path: f.Name(), Err: ErrWriteOnly}
+	}
+	o.flag = flag
+	m := newMmap(f, o)
+	err = m.open(o)
+	if err != nil {
+		if o.owned {
+			f.Close()
+		}
+		return nil, err
+	}
+	return m, nil
+}
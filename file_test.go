@@ -0,0 +1,96 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFromFile(t *testing.T) {
+	page := os.Getpagesize()
+	name, err := rndfile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := FromFile(f, WithFlag(os.O_RDWR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Data, data) || m.Name() != name {
+		t.Error("wrong data or name of mapped file")
+	}
+	_, err = m.WriteAt([]byte("test"), 0)
+	if err == nil {
+		t.Error("allowed to write to a file opened read-only")
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Stat()
+	if err != nil {
+		t.Error("file closed by a mapping that does not own it", err)
+	}
+	m, err = FromFile(f, WithOwnedFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	_, err = f.Stat()
+	if err == nil {
+		t.Error("file left open by a mapping that owns it")
+	}
+}
+
+func TestFromFd(t *testing.T) {
+	page := os.Getpagesize()
+	name, err := rndfile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	fd, err := syscall.Open(name, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	m, err := FromFd(uintptr(fd), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := rndmessage(2 * page)
+	_, err = m.WriteAt(msg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(msg))
+	_, err = syscall.Pread(fd, b, 0)
+	if err != nil {
+		t.Fatal("descriptor closed by a mapping that does not own it", err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Error("wrong data written through descriptor")
+	}
+}
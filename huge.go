@@ -0,0 +1,197 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bufio"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const hugetlbfsMagic = 0x958458f6 // statfs type of hugetlbfs
+
+// HugePageUsage reports the huge pages backing a mapping, as accounted in /proc/self/smaps.
+type HugePageUsage struct {
+	PageSize    int64 // size of the pages backing the mapping
+	Hugetlb     int64 // bytes backed by hugetlb pages
+	Transparent int64 // bytes backed by transparent huge pages
+}
+
+// Backed reports whether any part of the mapping is backed by huge pages.
+func (u *HugePageUsage) Backed() bool {
+	return u.Hugetlb > 0 || u.Transparent > 0
+}
+
+// HugePageSize returns the size of the hugetlb pages backing the mapping, or 0 for normal pages.
+func (m *Mmap) HugePageSize() int64 {
+	return m.hugePage
+}
+
+// HugePages reports how much of the mapping is actually backed by huge pages, hugetlb pages are
+// accounted once faulted in and transparent huge pages only when the kernel could allocate them.
+func (m *Mmap) HugePages() (*HugePageUsage, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return nil, m.wrap("smaps", 0, 0, ErrClosed)
+	}
+	u := &HugePageUsage{PageSize: pageSize}
+	if m.hugePage > 0 {
+		u.PageSize = m.hugePage
+	}
+	if m.mem == nil {
+		return u, nil
+	}
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		return nil, m.wrap("smaps", 0, 0, err)
+	}
+	defer f.Close()
+	from := uint64(uintptr(unsafe.Pointer(unsafe.SliceData(m.mem))))
+	to := from + uint64(len(m.mem))
+	var inside bool
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if lo, hi, ok := strings.Cut(fields[0], "-"); ok {
+			// Header of the next memory area
+			start, err1 := strconv.ParseUint(lo, 16, 64)
+			end, err2 := strconv.ParseUint(hi, 16, 64)
+			if err1 == nil && err2 == nil {
+				inside = start < to && end > from
+				continue
+			}
+		}
+		if !inside {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "KernelPageSize:":
+			u.PageSize = kb * 1024
+		case "Private_Hugetlb:", "Shared_Hugetlb:":
+			u.Hugetlb += kb * 1024
+		case "AnonHugePages:", "ShmemPmdMapped:", "FilePmdMapped:":
+			u.Transparent += kb * 1024
+		}
+	}
+	err = s.Err()
+	if err != nil {
+		return nil, m.wrap("smaps", 0, 0, err)
+	}
+	return u, nil
+}
+
+// Detect files on hugetlbfs, their mappings are backed by huge pages and their sizes are multiples of them
+func (m *Mmap) hugeFile(o options) error {
+	var stat syscall.Statfs_t
+	err := syscall.Fstatfs(int(m.fd.Fd()), &stat)
+	if err != nil {
+		return m.wrap("fstatfs", 0, 0, err)
+	}
+	if uint32(stat.Type) != hugetlbfsMagic {
+		if o.hugetlb {
+			return m.wrap("mmap", 0, 0, ErrUnsupported)
+		}
+		return nil
+	}
+	size := int64(stat.Bsize)
+	if o.ranged || o.window > 0 {
+		return m.wrap("mmap", 0, 0, ErrUnsupported)
+	}
+	if (o.hugetlb && o.hugePage > 0 && o.hugePage != size) || (o.size > 0 && o.size%size != 0) {
+		return m.wrap("mmap", 0, o.size, ErrInvalidRange)
+	}
+	m.hugePage = size
+	// Private mappings that grow move to anonymous memory, it has to be hugetlb too
+	m.mapFlags |= MAP_HUGETLB | hugeFlags(size)
+	return nil
+}
+
+// Round size up to a multiple of the hugetlb page size of the mapping
+func (m *Mmap) hugeAlign(size int64) int64 {
+	if m.hugePage == 0 {
+		return size
+	}
+	return (size + m.hugePage - 1) &^ (m.hugePage - 1)
+}
+
+// Map the file again with the new size, mremap can not grow hugetlb mappings.
+// The old memory is only unmapped once the new one is mapped, so a failure leaves the mapping intact.
+func (m *Mmap) hugeRemap(size int64) error {
+	if m.private {
+		return m.anonGrow(size)
+	}
+	fileSize := m.fileSize
+	if size > fileSize {
+		err := m.truncate(size)
+		if err != nil {
+			return err
+		}
+	}
+	mmapAddr, _, errno := syscall.Syscall6(
+		SYS_MMAP,
+		0,
+		uintptr(size),
+		uintptr(m.protection()),
+		uintptr(MAP_SHARED|m.mapFlags),
+		m.fd.Fd(),
+		0,
+	)
+	if errno != 0 {
+		m.restoreSize(size, fileSize)
+		return m.wrap("mmap", 0, size, errno)
+	}
+	mem := toSlice(mmapAddr, size)
+	err := unmap(m.mem)
+	if err != nil {
+		unmap(mem)
+		m.restoreSize(size, fileSize)
+		return m.wrap("munmap", 0, int64(len(m.mem)), err)
+	}
+	m.mem = mem
+	m.Data = mem
+	if size < fileSize {
+		err = m.truncate(size)
+		if err != nil {
+			return err
+		}
+	}
+	// The new memory is mapped with the mapping flags, like the first mapping
+	return m.setup(0)
+}
+
+// Shrink the file back to its size before a failed remap to size
+func (m *Mmap) restoreSize(size, fileSize int64) {
+	if size > fileSize {
+		m.truncate(fileSize)
+	}
+}
+
+// Return the default hugetlb page size, 0 when the kernel has no hugetlb support
+func defaultHugePage() int64 {
+	return procKB("/proc/meminfo", "Hugepagesize:")
+}
+
+// Encode a hugetlb page size in MAP_HUGE_* or MFD_HUGE_* flags, 0 selects the default size
+func hugeFlags(size int64) int {
+	if size == 0 {
+		return 0
+	}
+	return (bits.Len64(uint64(size)) - 1) << MAP_HUGE_SHIFT
+}
@@ -0,0 +1,155 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestHugeMemfd(t *testing.T) {
+	huge := defaultHugePage()
+	m, err := NewMemfd("huge", 2*huge, 0, WithHugeTLB(0))
+	if err != nil {
+		t.Skip("no hugetlb pages available:", err)
+	}
+	defer m.Close()
+	if m.HugePageSize() != huge {
+		t.Fatal("wrong huge page size", m.HugePageSize())
+	}
+	msg := rndmessage(100)
+	_, err = m.WriteAt(msg, huge-50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := m.HugePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Backed() || u.PageSize != huge || u.Hugetlb != 2*huge {
+		t.Error("mapping not backed by hugetlb pages", u)
+	}
+	err = m.Truncate(huge + 1)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("allowed to truncate to a size that is not huge page aligned", err)
+	}
+	// Grow the mapping past the reserved pages of the file
+	_, err = m.WriteAt(msg, 2*huge+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 2*huge+110 {
+		t.Error("wrong size after growing", m.Size())
+	}
+	stat, err := os.Stat("/proc/self/fd/" + strconv.Itoa(int(m.Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size()%huge != 0 {
+		t.Error("file size is not huge page aligned", stat.Size())
+	}
+	b := make([]byte, len(msg))
+	_, err = m.ReadAt(b, huge-50)
+	if err != nil || string(b) != string(msg) {
+		t.Error("data lost after growing", err)
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Error("failed to trim the file", err)
+	}
+}
+
+func TestHugeAnonymous(t *testing.T) {
+	huge := defaultHugePage()
+	_, err := NewAnonymous(huge+1, WithHugeTLB(0))
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("allowed an anonymous mapping that is not huge page aligned", err)
+	}
+	m, err := NewAnonymous(huge, WithHugeTLB(0))
+	if err != nil {
+		t.Skip("no hugetlb pages available:", err)
+	}
+	defer m.Close()
+	_, err = m.WriteAt(rndmessage(100), huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := m.HugePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Hugetlb != 2*huge {
+		t.Error("grown mapping not backed by hugetlb pages", u)
+	}
+}
+
+func TestHugeOptions(t *testing.T) {
+	name := tmpname()
+	defer os.Remove(name)
+	_, err := Open(name, WithFlag(os.O_RDWR|os.O_CREATE), WithHugeTLB(0))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Error("allowed hugetlb mapping of a file that is not on hugetlbfs", err)
+	}
+	_, err = NewAnonymous(0, WithHugeTLB(3<<20))
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("allowed a huge page size that is not a power of two", err)
+	}
+	m, err := Create(name, 100, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	u, err := m.HugePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HugePageSize() != 0 || u.Hugetlb != 0 || u.PageSize != int64(os.Getpagesize()) {
+		t.Error("wrong huge page usage of a normal mapping", u)
+	}
+}
+
+func TestHugeRemapFailure(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name, err := rndfile(int(2 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := Open(name, WithFlag(os.O_RDWR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	msg := append([]byte(nil), m.Data...)
+	// Regular files can not be mapped with MAP_HUGETLB, so mapping the new size fails
+	m.Lock()
+	m.mapFlags |= MAP_HUGETLB
+	err = m.hugeRemap(8 * page)
+	m.mapFlags &^= MAP_HUGETLB
+	m.Unlock()
+	if err == nil {
+		t.Fatal("remapped a regular file with MAP_HUGETLB")
+	}
+	if !bytes.Equal(m.Data, msg) {
+		t.Error("mapping changed by a failed remap")
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Error("file changed by a failed remap", len(b))
+	}
+}
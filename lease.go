@@ -0,0 +1,134 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// ErrLeased is returned by operations that would move or unmap memory borrowed by an outstanding Lease.
+var ErrLeased = errors.New("mapping has outstanding leases")
+
+// Lease is a zero-copy view of a part of the mapping returned by Borrow.
+// The mapping does not move, grow through mremap or shrink while the lease is outstanding.
+type Lease struct {
+	m        *Mmap
+	b        []byte
+	off      int64
+	released atomic.Bool
+}
+
+// LeaseError records a lease that was still outstanding when the mapping was closed,
+// with the stack of the goroutine that borrowed it when lease debugging is enabled.
+type LeaseError struct {
+	Path  string
+	Off   int64 // file offset of the lease
+	Len   int64
+	Stack []byte // stack of the Borrow call, nil without WithLeaseDebug
+}
+
+func (e *LeaseError) Error() string {
+	s := "lease " + e.Path + " [" + strconv.FormatInt(e.Off, 10) + ":" +
+		strconv.FormatInt(e.Off+e.Len, 10) + "] not released"
+	if e.Stack != nil {
+		s += ", borrowed at:\n" + string(e.Stack)
+	}
+	return s
+}
+
+// Is reports whether target is ErrLeased.
+func (e *LeaseError) Is(target error) bool {
+	return target == ErrLeased
+}
+
+// Borrow returns a lease on n bytes of the mapping starting at file offset off. The bytes of the
+// lease stay valid until Release, operations that would move the mapping fail with ErrLeased
+// meanwhile. Changes made through the lease are not tracked, report them with MarkDirty.
+// Windowed mappings can not be borrowed from.
+func (m *Mmap) Borrow(off, n int64) (*Lease, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return nil, m.wrap("borrow", off, n, ErrClosed)
+	}
+	if m.window > 0 {
+		return nil, m.wrap("borrow", off, n, ErrUnsupported)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end {
+		return nil, m.wrap("borrow", off, n, ErrOutOfRange)
+	}
+	l := &Lease{m: m, b: m.Data[off-m.start : off-m.start+n : off-m.start+n], off: off}
+	m.leases.Add(1)
+	if m.leaseDebug {
+		m.leaseMu.Lock()
+		m.leased[l] = debug.Stack()
+		m.leaseMu.Unlock()
+	}
+	return l, nil
+}
+
+// Bytes returns the borrowed bytes, or nil after Release.
+func (l *Lease) Bytes() []byte {
+	if l.released.Load() {
+		return nil
+	}
+	return l.b
+}
+
+// Offset returns the file offset of the borrowed bytes.
+func (l *Lease) Offset() int64 {
+	return l.off
+}
+
+// Release ends the lease, the borrowed bytes must not be used afterwards.
+// Calling Release again does nothing.
+func (l *Lease) Release() {
+	if l.released.Swap(true) {
+		return
+	}
+	m := l.m
+	if m.leaseDebug {
+		m.leaseMu.Lock()
+		delete(m.leased, l)
+		m.leaseMu.Unlock()
+	}
+	m.leases.Add(-1)
+}
+
+// Fail when outstanding leases would be invalidated by moving the mapping, the mapping must be locked
+func (m *Mmap) checkLeases(op string, off, length int64) error {
+	if m.leases.Load() > 0 {
+		return m.wrap(op, off, length, ErrLeased)
+	}
+	return nil
+}
+
+// Report the leases that are still outstanding when the mapping is closed
+func (m *Mmap) leaked() error {
+	if m.leases.Load() == 0 {
+		return nil
+	}
+	if !m.leaseDebug {
+		return m.wrap("close", 0, 0, ErrLeased)
+	}
+	m.leaseMu.Lock()
+	errs := make([]error, 0, len(m.leased))
+	for l, stack := range m.leased {
+		errs = append(errs, &LeaseError{Path: m.Name(), Off: l.off, Len: int64(len(l.b)), Stack: stack})
+	}
+	m.leaseMu.Unlock()
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].(*LeaseError).Off < errs[j].(*LeaseError).Off
+	})
+	return errors.Join(errs...)
+}
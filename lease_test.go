@@ -0,0 +1,77 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBorrow(t *testing.T) {
+	page := os.Getpagesize()
+	name, err := rndfile(2 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(name, WithFlag(os.O_RDWR), WithLeaseDebug())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := m.Borrow(100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(l.Bytes(), data[100:300]) {
+		t.Error("wrong borrowed data")
+	}
+	_, err = m.Borrow(int64(page), int64(2*page))
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to borrow past the end of the mapping", err)
+	}
+	_, err = m.WriteAt([]byte("test"), int64(4*page))
+	if !errors.Is(err, ErrLeased) {
+		t.Error("allowed to grow the mapping while leased", err)
+	}
+	err = m.Truncate(int64(page))
+	if !errors.Is(err, ErrLeased) {
+		t.Error("allowed to truncate the mapping while leased", err)
+	}
+	_, err = m.WriteAt([]byte("test"), 0)
+	if err != nil {
+		t.Error("failed to write in place while leased", err)
+	}
+	l.Release()
+	l.Release()
+	if l.Bytes() != nil {
+		t.Error("borrowed data available after Release")
+	}
+	err = m.Truncate(int64(4 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Borrow(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	var lerr *LeaseError
+	if !errors.As(err, &lerr) {
+		t.Fatal("leaked lease not reported by Close", err)
+	}
+	if lerr.Off != 0 || lerr.Len != 10 || !strings.Contains(string(lerr.Stack), "TestBorrow") {
+		t.Error("wrong leaked lease reported", lerr)
+	}
+}
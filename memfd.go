@@ -0,0 +1,218 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// NewAnonymous creates an anonymous private mapping of the given size that is not backed by any file,
+// for scratch buffers that still get the Mmap API. The mapping grows and shrinks like a file mapping,
+// Sync does nothing and Name returns an empty string.
+func NewAnonymous(size int64, opts ...Option) (*Mmap, error) {
+	o, err := parseOptions("", opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.ranged || o.window > 0 {
+		return nil, &Error{Op: "mmap", Err: ErrUnsupported}
+	}
+	if size < 0 {
+		return nil, &Error{Op: "mmap", Len: size, Err: ErrInvalidRange}
+	}
+	m := newMmap(nil, o)
+	m.flag = os.O_RDWR
+	m.private = true
+	m.anon = true
+	if o.hugetlb {
+		m.hugePage = o.hugePage
+		if m.hugePage == 0 {
+			m.hugePage = defaultHugePage()
+		}
+		if m.hugePage == 0 {
+			return nil, &Error{Op: "mmap", Len: size, Err: ErrUnsupported}
+		}
+		if size%m.hugePage != 0 {
+			return nil, &Error{Op: "mmap", Len: size, Err: ErrInvalidRange}
+		}
+		m.mapFlags |= MAP_HUGETLB | hugeFlags(o.hugePage)
+	}
+	if size == 0 {
+		return m, nil
+	}
+	mem, err := mmapAnon(size, m.mapFlags)
+	if err != nil {
+		return nil, &Error{Op: "mmap", Len: size, Err: err}
+	}
+	m.mem = mem
+	m.Data = mem
+	err = m.setup(0)
+	if err != nil {
+		unmap(mem)
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewMemfd creates an anonymous memory file of the given size with memfd_create(2) and maps it shared.
+// flags are MFD_* flags, MFD_ALLOW_SEALING allows Seal. The descriptor returned by Fd can be passed to
+// a child process, that maps the same memory with OpenFd. The name is only used for debugging.
+// With WithHugeTLB the file is created with MFD_HUGETLB and size must be a multiple of the huge page size.
+func NewMemfd(name string, size int64, flags int, opts ...Option) (*Mmap, error) {
+	o, err := parseOptions("memfd:"+name, append([]Option{WithFlag(os.O_RDWR)}, opts...))
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || o.ranged || o.window > 0 || o.size >= 0 {
+		return nil, &Error{Op: "memfd_create", Path: "memfd:" + name, Err: ErrInvalidRange}
+	}
+	if o.hugetlb {
+		flags |= MFD_HUGETLB | hugeFlags(o.hugePage)
+	}
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, &Error{Op: "memfd_create", Path: "memfd:" + name, Err: err}
+	}
+	fd, _, errno := syscall.Syscall(SYS_MEMFD_CREATE, uintptr(unsafe.Pointer(p)), uintptr(flags|MFD_CLOEXEC), 0)
+	if errno != 0 {
+		return nil, &Error{Op: "memfd_create", Path: "memfd:" + name, Err: errno}
+	}
+	f := os.NewFile(fd, "memfd:"+name)
+	o.size = size
+	o.owned = true
+	m := newMmap(f, o)
+	err = m.open(o)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// OpenFd maps the file behind the descriptor fd, usually a memory file inherited from the process that
+// created it with NewMemfd. The mapping owns fd and closes it on Close, like FromFd with WithOwnedFile.
+func OpenFd(fd uintptr, opts ...Option) (*Mmap, error) {
+	name, err := os.Readlink("/proc/self/fd/" + strconv.FormatUint(uint64(fd), 10))
+	if err != nil {
+		name = "/dev/fd/" + strconv.FormatUint(uint64(fd), 10)
+	}
+	return FromFd(fd, name, append(opts, WithOwnedFile())...)
+}
+
+// Fd returns the file descriptor backing the mapping, or ^uintptr(0) for anonymous mappings.
+func (m *Mmap) Fd() uintptr {
+	return m.fd.Fd()
+}
+
+// Seal adds the given F_SEAL_* seals to a memory file created with MFD_ALLOW_SEALING.
+// The file is trimmed to the size of the mapping first. With F_SEAL_WRITE the mapping is made read-only
+// before sealing, it still fails while other writable shared mappings of the file exist.
+func (m *Mmap) Seal(seals int) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return m.wrap("seal", 0, 0, ErrClosed)
+	}
+	if m.fd == nil {
+		return m.wrap("seal", 0, 0, ErrUnsupported)
+	}
+	err := m.trim()
+	if err != nil {
+		return err
+	}
+	if seals&F_SEAL_WRITE != 0 {
+		err = m.readOnly()
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fcntl(m.fd.Fd(), F_ADD_SEALS, seals)
+	if err != nil {
+		return m.wrap("fcntl", 0, 0, err)
+	}
+	return nil
+}
+
+// Seals returns the F_SEAL_* seals of the memory file backing the mapping.
+func (m *Mmap) Seals() (int, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return 0, m.wrap("seal", 0, 0, ErrClosed)
+	}
+	if m.fd == nil {
+		return 0, m.wrap("seal", 0, 0, ErrUnsupported)
+	}
+	seals, err := fcntl(m.fd.Fd(), F_GET_SEALS, 0)
+	if err != nil {
+		return 0, m.wrap("fcntl", 0, 0, err)
+	}
+	return seals, nil
+}
+
+// Map the file again read-only. Shared mappings of a descriptor opened for writing count as writable
+// even without PROT_WRITE, so the new memory is mapped through a read-only descriptor of the file.
+func (m *Mmap) readOnly() error {
+	if m.private || m.flag&syscall.O_ACCMODE != os.O_RDWR || m.mem == nil {
+		m.prot = PROT_READ
+		return nil
+	}
+	err := m.checkLeases("mmap", 0, 0)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile("/proc/self/fd/"+strconv.FormatUint(uint64(m.fd.Fd()), 10), os.O_RDONLY, 0)
+	if err != nil {
+		return m.wrap("open", 0, 0, err)
+	}
+	defer f.Close()
+	base := m.start &^ (pageSize - 1)
+	mmapAddr, _, errno := syscall.Syscall6(
+		SYS_MMAP,
+		0,
+		uintptr(len(m.mem)),
+		uintptr(PROT_READ),
+		uintptr(MAP_SHARED|m.mapFlags),
+		f.Fd(),
+		uintptr(base>>mmapOffsetShift),
+	)
+	if errno != 0 {
+		return m.wrap("mmap", base, int64(len(m.mem)), errno)
+	}
+	err = unmap(m.mem)
+	if err != nil {
+		unmap(toSlice(mmapAddr, int64(len(m.mem))))
+		return m.wrap("munmap", base, int64(len(m.mem)), err)
+	}
+	size := int64(len(m.Data))
+	m.mem = toSlice(mmapAddr, int64(len(m.mem)))
+	m.Data = m.mem[m.start-base : m.start-base+size]
+	m.flag = m.flag&^syscall.O_ACCMODE | os.O_RDONLY
+	m.prot = PROT_READ
+	// Ranges protected by Mprotect can not be writable any more
+	var protect []rangeAttr
+	for _, r := range m.protect {
+		if r.attr&^PROT_WRITE != PROT_READ {
+			protect = append(protect, rangeAttr{r.Range, r.attr &^ PROT_WRITE})
+		}
+	}
+	m.protect = protect
+	return m.setup(0)
+}
+
+// Manipulate a file descriptor
+func fcntl(fd uintptr, cmd, arg int) (int, error) {
+	r, _, errno := syscall.Syscall(SYS_FCNTL, fd, uintptr(cmd), uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
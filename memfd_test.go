@@ -0,0 +1,121 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestAnonymous(t *testing.T) {
+	page := os.Getpagesize()
+	m, err := NewAnonymous(int64(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Size() != int64(page) || m.Name() != "" {
+		t.Error("wrong size or name of anonymous mapping")
+	}
+	msg := rndmessage(3 * page)
+	_, err = m.WriteAt(msg, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(msg))
+	_, err = m.ReadAt(b, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Error("wrong data read from anonymous mapping")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Truncate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != int64(len(msg)) {
+		t.Error("wrong size after growing anonymous mapping")
+	}
+}
+
+func TestMemfd(t *testing.T) {
+	page := os.Getpagesize()
+	m, err := NewMemfd("test", int64(page), MFD_ALLOW_SEALING)
+	if err != nil {
+		t.Skip("memfd not available:", err)
+	}
+	defer m.Close()
+	msg := rndmessage(2 * page)
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Seal(F_SEAL_SHRINK | F_SEAL_GROW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seals, err := m.Seals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seals != F_SEAL_SHRINK|F_SEAL_GROW {
+		t.Error("wrong seals", seals)
+	}
+	err = m.Truncate(int64(page))
+	if !errors.Is(err, syscall.EPERM) {
+		t.Error("allowed to shrink a sealed memory file", err)
+	}
+	fd, err := syscall.Dup(int(m.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenFd(uintptr(fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Error("wrong data in memory file opened by descriptor")
+	}
+	err = m.Seal(F_SEAL_WRITE)
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Error("sealed writes while another writable mapping exists", err)
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Seal(F_SEAL_WRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.WriteAt(msg[:10], 0)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("allowed to write to a write sealed memory file", err)
+	}
+	if m.Protection() != PROT_READ || !bytes.Equal(m.Data, msg) {
+		t.Error("wrong mapping after sealing writes")
+	}
+}
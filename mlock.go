@@ -0,0 +1,178 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ErrMemlockLimit matches errors of Mlock caused by exceeding RLIMIT_MEMLOCK with errors.Is,
+// other failures keep their errno, like syscall.EPERM when locking is not permitted at all.
+var ErrMemlockLimit = errors.New("locked memory limit exceeded")
+
+// Lock attribute of the ranges unlocked by Munlock in mappings locked by WithLocked
+const unlocked = -1
+
+// Error of mlock that ran into RLIMIT_MEMLOCK, it matches both ErrMemlockLimit and the errno
+type memlockError struct {
+	errno syscall.Errno
+}
+
+func (e *memlockError) Error() string {
+	return ErrMemlockLimit.Error() + ": " + e.errno.Error()
+}
+
+func (e *memlockError) Unwrap() []error {
+	return []error{ErrMemlockLimit, e.errno}
+}
+
+// Mlock locks n bytes of the mapping starting at file offset off to RAM. off must be page aligned and
+// n is rounded up to a multiple of the page size. flags is 0 to fault in and lock all the pages now,
+// or MLOCK_ONFAULT to lock them as they are faulted in. The locks are established again when the
+// mapping grows or moves, and released on Munlock or Close. Windowed mappings are not supported.
+func (m *Mmap) Mlock(off, n int64, flags int) error {
+	m.Lock()
+	defer m.Unlock()
+	from, to, err := m.pageSpan("mlock", off, n)
+	if err != nil || from == to {
+		return err
+	}
+	err = mlock(m.pages(from, to), flags)
+	if err != nil {
+		return m.wrap("mlock", off, n, err)
+	}
+	m.locks = setRange(m.locks, from, to, flags, true)
+	return nil
+}
+
+// Munlock unlocks n bytes of the mapping starting at the page aligned file offset off,
+// including pages locked by WithLocked, that stay unlocked when the mapping grows or moves.
+func (m *Mmap) Munlock(off, n int64) error {
+	m.Lock()
+	defer m.Unlock()
+	from, to, err := m.pageSpan("munlock", off, n)
+	if err != nil || from == to {
+		return err
+	}
+	err = munlock(m.pages(from, to))
+	if err != nil {
+		return m.wrap("munlock", off, n, err)
+	}
+	locked := m.mapFlags&MAP_LOCKED != 0
+	m.locks = setRange(m.locks, from, to, unlocked, locked)
+	return nil
+}
+
+// Lock the ranges locked by Mlock again after the mapping was mapped or grown,
+// dropping the ranges that are not mapped any more
+func (m *Mmap) relock() error {
+	m.locks = m.clipRanges(m.locks)
+	if m.mapFlags&MAP_LOCKED != 0 && m.mem != nil {
+		// The whole mapping was unlocked before it was moved
+		err := mlock(m.mem, 0)
+		if err != nil {
+			return m.wrap("mlock", m.start&^(pageSize-1), int64(len(m.mem)), err)
+		}
+	}
+	for _, r := range m.locks {
+		var err error
+		if r.attr == unlocked {
+			err = munlock(m.pages(r.Off, r.Off+r.Len))
+		} else {
+			err = mlock(m.pages(r.Off, r.Off+r.Len), r.attr)
+		}
+		if err != nil {
+			return m.wrap("mlock", r.Off, r.Len, err)
+		}
+	}
+	return nil
+}
+
+// Unlock the whole mapping, mremap fails on mappings split by mlock or munlock
+func (m *Mmap) unlock() error {
+	if (len(m.locks) == 0 && m.mapFlags&MAP_LOCKED == 0) || m.mem == nil {
+		return nil
+	}
+	err := munlock(m.mem)
+	if err != nil {
+		return m.wrap("munlock", m.start&^(pageSize-1), int64(len(m.mem)), err)
+	}
+	return nil
+}
+
+// Lock a memory region to RAM
+func mlock(mem []byte, flags int) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MLOCK2, uintptr(addr), uintptr(len(mem)), uintptr(flags))
+	if errno == syscall.ENOSYS && flags == 0 {
+		_, _, errno = syscall.Syscall(SYS_MLOCK, uintptr(addr), uintptr(len(mem)), 0)
+	}
+	if errno != 0 {
+		return lockError(errno, int64(len(mem)))
+	}
+	return nil
+}
+
+// Unlock a memory region
+func munlock(mem []byte) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MUNLOCK, uintptr(addr), uintptr(len(mem)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Tell a failure to lock n more bytes because of RLIMIT_MEMLOCK apart from other failures
+func lockError(errno syscall.Errno, n int64) error {
+	if errno != syscall.ENOMEM && errno != syscall.EAGAIN {
+		return errno
+	}
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(RLIMIT_MEMLOCK, &limit)
+	if err != nil || limit.Cur == ^uint64(0) {
+		return errno
+	}
+	if uint64(lockedBytes()+n) > limit.Cur {
+		return &memlockError{errno: errno}
+	}
+	return errno
+}
+
+// Return the bytes of memory the process has locked, from /proc/self/status
+func lockedBytes() int64 {
+	return procKB("/proc/self/status", "VmLck:")
+}
+
+// Return the size in a kB field of a /proc file, 0 when it is missing
+func procKB(name, field string) int64 {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, ok := strings.CutPrefix(s.Text(), field)
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(line, "kB")), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
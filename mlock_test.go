@@ -0,0 +1,149 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMlock(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name := tmpname()
+	m, err := Create(name, 4*page, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	err = m.Mlock(page, page, MLOCK_ONFAULT)
+	if err != nil {
+		t.Skip("locking not available:", err)
+	}
+	err = m.Mlock(0, page, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Grow the mapping so it is moved by mremap
+	_, err = m.WriteAt([]byte("test"), 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.locks) != 2 || m.locks[1].attr != MLOCK_ONFAULT {
+		t.Error("locks not kept after growing", m.locks)
+	}
+	err = m.Munlock(0, 2*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.locks) != 0 {
+		t.Error("locks kept after unlocking", m.locks)
+	}
+	err = m.Mlock(1, page, 0)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("allowed to lock an unaligned range", err)
+	}
+	// Private mappings move to anonymous memory when they grow
+	name, err = rndfile(int(4 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	p, err := OpenPrivate(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.Mlock(0, page, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.WriteAt([]byte("test"), 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.locks) != 1 || !strings.Contains(vmFlags(t, p.Data), " lo ") {
+		t.Error("locks of private mapping not kept after growing", p.locks)
+	}
+	if strings.Contains(vmFlags(t, p.Data[page:]), " lo ") {
+		t.Error("private mapping locked outside the locked range")
+	}
+}
+
+func TestMemlockLimit(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("the locked memory limit does not apply to root")
+	}
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(RLIMIT_MEMLOCK, &limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowered := limit
+	lowered.Cur = uint64(os.Getpagesize())
+	err = syscall.Setrlimit(RLIMIT_MEMLOCK, &lowered)
+	if err != nil {
+		t.Skip("can not lower the locked memory limit:", err)
+	}
+	defer syscall.Setrlimit(RLIMIT_MEMLOCK, &limit)
+	m, err := NewAnonymous(int64(16 * os.Getpagesize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	err = m.Mlock(0, m.Size(), 0)
+	if !errors.Is(err, ErrMemlockLimit) {
+		t.Error("wrong error exceeding the locked memory limit", err)
+	}
+}
+
+func TestMunlockLocked(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name := tmpname()
+	defer os.Remove(name)
+	f, err := Open(name, WithFlag(os.O_RDWR|os.O_CREATE), WithSize(4*page), WithLocked())
+	if err != nil {
+		t.Skip("locked mappings not available:", err)
+	}
+	defer f.Close()
+	a, err := NewAnonymous(4*page, WithLocked())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	grow := map[*Mmap]func() error{
+		f: func() error {
+			_, err := f.WriteAt([]byte("test"), 64*page)
+			return err
+		},
+		a: func() error {
+			return a.Truncate(64 * page)
+		},
+	}
+	for m, grow := range grow {
+		err = m.Munlock(0, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = grow()
+		if err != nil {
+			t.Fatal("failed to grow a partly unlocked mapping:", err)
+		}
+		if strings.Contains(vmFlags(t, m.Data), " lo ") {
+			t.Error("unlocked pages locked again after growing")
+		}
+		for _, off := range []int64{page, 32 * page} {
+			if !strings.Contains(vmFlags(t, m.Data[off:]), " lo ") {
+				t.Error("locked mapping not locked after growing at", off)
+			}
+		}
+	}
+}
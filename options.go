@@ -0,0 +1,285 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Option configures how Open opens and maps a file.
+type Option func(*options)
+
+type options struct {
+	flag       int
+	perm       uint32
+	size       int64
+	off        int64
+	length     int64
+	ranged     bool
+	window     int64
+	private    bool
+	mapFlags   int
+	advice     []int
+	prot       int
+	growth     GrowthPolicy
+	leaseDebug bool
+	owned      bool
+	hugetlb    bool
+	hugePage   int64
+}
+
+// GrowthPolicy returns the new capacity of a mapping of the given capacity that has to hold needed bytes.
+type GrowthPolicy func(capacity, needed int64) int64
+
+// GrowGeometric doubles the capacity until it holds the needed bytes, so appends cost amortized constant time.
+func GrowGeometric(capacity, needed int64) int64 {
+	c := capacity
+	if c < pageSize {
+		c = pageSize
+	}
+	for c < needed && c <= maxSize/2 {
+		c *= 2
+	}
+	if c < needed {
+		// Doubling again would go past the largest mapping
+		c = needed
+		if c < maxSize {
+			c = maxSize
+		}
+	}
+	return c
+}
+
+// GrowExact grows the capacity to exactly the needed bytes, every write past the end of file resizes the file.
+func GrowExact(capacity, needed int64) int64 {
+	return needed
+}
+
+// WithFlag sets the flags used to open the file, os.O_RDONLY by default.
+// Files are opened os.O_RDWR instead of os.O_WRONLY, mappings can not be write-only.
+func WithFlag(flag int) Option {
+	return func(o *options) {
+		o.flag = flag
+	}
+}
+
+// WithPerm sets the permissions of a created file, 0644 by default.
+func WithPerm(perm uint32) Option {
+	return func(o *options) {
+		o.perm = perm
+	}
+}
+
+// WithSize sets the size of the file, like Create does.
+func WithSize(size int64) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// WithRange maps only length bytes of the file starting at file offset off, like OpenRange does.
+func WithRange(off, length int64) Option {
+	return func(o *options) {
+		o.off = off
+		o.length = length
+		o.ranged = true
+	}
+}
+
+// WithWindow maps the file through a sliding window of the given size, like OpenWindow does.
+func WithWindow(window int64) Option {
+	return func(o *options) {
+		o.window = window
+	}
+}
+
+// WithPrivate creates a private copy-on-write mapping, like OpenPrivate does.
+func WithPrivate() Option {
+	return func(o *options) {
+		o.private = true
+	}
+}
+
+// WithPopulate prefaults the page tables of the mapping (MAP_POPULATE).
+func WithPopulate() Option {
+	return func(o *options) {
+		o.mapFlags |= MAP_POPULATE
+	}
+}
+
+// WithLocked locks the pages of the mapping to RAM (MAP_LOCKED).
+func WithLocked() Option {
+	return func(o *options) {
+		o.mapFlags |= MAP_LOCKED
+	}
+}
+
+// WithHugePages hints the kernel to back the mapping with transparent huge pages (MADV_HUGEPAGE).
+func WithHugePages() Option {
+	return func(o *options) {
+		o.advice = append(o.advice, MADV_HUGEPAGE)
+	}
+}
+
+// WithHugeTLB backs the mapping with hugetlb pages of the given size, or of the default size when 0,
+// from the pool reserved in /proc/sys/vm/nr_hugepages. It applies to NewAnonymous and NewMemfd, files
+// must be on hugetlbfs, where they are always backed by the huge pages of the filesystem. The sizes
+// given to Create and Truncate must be multiples of the huge page size, growth rounds up to it.
+func WithHugeTLB(size int64) Option {
+	return func(o *options) {
+		o.hugetlb = true
+		o.hugePage = size
+	}
+}
+
+// WithAdvice applies the given madvise advice to the mapping.
+func WithAdvice(advice int) Option {
+	return func(o *options) {
+		o.advice = append(o.advice, advice)
+	}
+}
+
+// WithProtection overrides the page protection of the mapping that is otherwise derived from the open flags.
+func WithProtection(prot int) Option {
+	return func(o *options) {
+		o.prot = prot
+	}
+}
+
+// WithGrowth sets the policy used to grow the capacity of the mapping when Write or WriteAt go past
+// the end of file, GrowGeometric by default.
+func WithGrowth(growth GrowthPolicy) Option {
+	return func(o *options) {
+		o.growth = growth
+	}
+}
+
+// WithLeaseDebug records the stack of every Borrow call, so Close reports where leaked leases were borrowed.
+func WithLeaseDebug() Option {
+	return func(o *options) {
+		o.leaseDebug = true
+	}
+}
+
+// WithOwnedFile hands the file or descriptor passed to FromFile or FromFd over to the mapping,
+// so Close closes it. Files opened by Open are always owned by the mapping.
+func WithOwnedFile() Option {
+	return func(o *options) {
+		o.owned = true
+	}
+}
+
+// Open opens or creates the named file as memory-mapped, configured by the given options.
+// Mapping flags, advice and protection are applied again whenever the mapping grows.
+func Open(name string, opts ...Option) (*Mmap, error) {
+	o, err := parseOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.flag&syscall.O_ACCMODE == os.O_WRONLY {
+		// Mappings can not be write-only, writing to them needs read access to the file
+		o.flag = o.flag&^os.O_WRONLY | os.O_RDWR
+	}
+	f, err := os.OpenFile(name, o.flag, os.FileMode(o.perm))
+	if err != nil {
+		return nil, err
+	}
+	o.owned = true
+	m := newMmap(f, o)
+	err = m.open(o)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Apply the options of a mapping of the named file and check that they can be combined
+func parseOptions(name string, opts []Option) (options, error) {
+	o := options{flag: os.O_RDONLY, perm: 0644, size: -1, prot: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.window > 0 && (o.ranged || o.private || o.size >= 0) {
+		return o, &Error{Op: "open", Path: name, Err: errors.New("windowed mappings can not be combined with range, private or size options")}
+	}
+	if o.size >= 0 && (o.ranged || o.private) {
+		return o, &Error{Op: "open", Path: name, Err: errors.New("the size option can not be combined with range or private options")}
+	}
+	if o.hugetlb && (o.ranged || o.window > 0) {
+		return o, &Error{Op: "open", Path: name, Err: errors.New("hugetlb mappings can not be combined with range or window options")}
+	}
+	if o.hugetlb && (o.hugePage < 0 || o.hugePage&(o.hugePage-1) != 0 || (o.hugePage > 0 && o.hugePage <= pageSize)) {
+		return o, &Error{Op: "open", Path: name, Len: o.hugePage, Err: ErrInvalidRange}
+	}
+	if o.window < 0 || o.window > maxSize {
+		return o, &Error{Op: "open", Path: name, Err: ErrInvalidRange}
+	}
+	if o.ranged {
+		if o.flag&os.O_APPEND != 0 {
+			return o, &Error{Op: "open", Path: name, Err: ErrUnsupported}
+		}
+		if o.off < 0 || o.length <= 0 {
+			return o, &Error{Op: "open", Path: name, Off: o.off, Len: o.length, Err: ErrInvalidRange}
+		}
+	}
+	return o, nil
+}
+
+// Create an unmapped Mmap of the file f, configured by the options
+func newMmap(f *os.File, o options) *Mmap {
+	m := new(Mmap)
+	m.fd = f
+	m.flag = o.flag
+	m.append = o.flag&os.O_APPEND != 0
+	m.private = o.private
+	m.mapFlags = o.mapFlags
+	m.advice = o.advice
+	m.prot = o.prot
+	m.growth = o.growth
+	m.owned = o.owned
+	if o.leaseDebug {
+		m.leaseDebug = true
+		m.leased = make(map[*Lease][]byte)
+	}
+	return m
+}
+
+// Map the file as configured by the options
+func (m *Mmap) open(o options) (err error) {
+	err = m.hugeFile(o)
+	if err != nil {
+		return err
+	}
+	switch {
+	case o.window > 0:
+		m.window = (o.window + pageSize - 1) &^ (pageSize - 1)
+		var stat os.FileInfo
+		stat, err = m.fd.Stat()
+		if err == nil {
+			m.size = stat.Size()
+		}
+	case o.ranged:
+		m.ranged = true
+		err = m.mmap(o.off, o.length)
+		m.offset = o.off
+	case o.size > 0:
+		err = m.mmap(0, o.size)
+	case o.size == 0:
+		err = m.truncate(0)
+	default:
+		var stat os.FileInfo
+		stat, err = m.fd.Stat()
+		if err == nil && stat.Size() > 0 {
+			err = m.mmap(0, stat.Size())
+		}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	name := tmpname()
+	size := int64(os.Getpagesize())
+	m, err := Open(name,
+		WithFlag(os.O_RDWR|os.O_CREATE),
+		WithPerm(0600),
+		WithSize(size),
+		WithPopulate(),
+		WithAdvice(MADV_SEQUENTIAL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	if m.Size() != size {
+		t.Error("wrong size of created file")
+	}
+	msg := rndmessage(os.Getpagesize() * 3)
+	_, err = m.WriteAt(msg, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Data[size:], msg) {
+		t.Error("wrong data after growing")
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode().Perm() != 0600 {
+		t.Error("wrong permissions of created file")
+	}
+	_, err = Open(name, WithWindow(size), WithPrivate())
+	if err == nil {
+		t.Error("allowed to combine windowed and private mappings")
+	}
+}
+
+func TestOpenLocked(t *testing.T) {
+	name := tmpname()
+	m, err := Open(name, WithFlag(os.O_RDWR|os.O_CREATE), WithSize(int64(os.Getpagesize())), WithLocked())
+	if err != nil {
+		t.Skip("locked mappings not available:", err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	err = m.Truncate(int64(os.Getpagesize() * 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenPopulate(t *testing.T) {
+	page := int64(os.Getpagesize())
+	m, err := NewAnonymous(page, WithPopulate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	err = m.Truncate(8 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := m.Resident(page, 7*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Percent() != 100 {
+		t.Error("grown part of the mapping not populated", r.Pages)
+	}
+}
+
+func TestOpenProtection(t *testing.T) {
+	name, err := rndfile(os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := Open(name, WithFlag(os.O_RDWR), WithProtection(PROT_READ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	_, err = m.WriteAt([]byte("test"), 0)
+	if err == nil {
+		t.Error("allowed to write to a read-only mapping")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Set once the kernel rejected MADV_POPULATE_READ or MADV_POPULATE_WRITE, it needs Linux 5.14
+var noPopulate atomic.Bool
+
+// Prefault faults in the pages holding n bytes of the mapping starting at file offset off, so later
+// accesses do not stall on page faults, unlike MADV_WILLNEED that is only a hint. With write the pages
+// are faulted in writable, breaking copy-on-write of private mappings. It uses MADV_POPULATE_READ or
+// MADV_POPULATE_WRITE and falls back to touching every page on kernels without them.
+// It returns the number of pages that were not resident before, as reported by mincore(2), and how long
+// it took. Faults while touching pages, like past the end of a truncated file, return a *FaultError.
+// Windowed mappings are not supported.
+func (m *Mmap) Prefault(off, n int64, write bool) (int64, time.Duration, error) {
+	return m.PrefaultParallel(off, n, write, 1)
+}
+
+// PrefaultParallel is like Prefault, but splits the range among the given number of goroutines.
+func (m *Mmap) PrefaultParallel(off, n int64, write bool, workers int) (int64, time.Duration, error) {
+	begin := time.Now()
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return 0, 0, m.wrap("prefault", off, n, ErrClosed)
+	}
+	if m.window > 0 {
+		return 0, 0, m.wrap("prefault", off, n, ErrUnsupported)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end || workers < 1 {
+		return 0, 0, m.wrap("prefault", off, n, ErrOutOfRange)
+	}
+	from := off &^ (pageSize - 1)
+	to := (off + n + pageSize - 1) &^ (pageSize - 1)
+	if n == 0 {
+		return 0, time.Since(begin), nil
+	}
+	if write && !m.allows(from, to-from, PROT_READ|PROT_WRITE) {
+		return 0, 0, m.wrap("prefault", off, n, ErrReadOnly)
+	}
+	if !m.allows(from, to-from, PROT_READ) {
+		return 0, 0, m.wrap("prefault", off, n, syscall.EACCES)
+	}
+	pages := (to - from) / pageSize
+	if int64(workers) > pages {
+		workers = int(pages)
+	}
+	chunk := (pages + int64(workers) - 1) / int64(workers) * pageSize
+	faulted := make([]int64, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		lo := from + int64(i)*chunk
+		hi := lo + chunk
+		if hi > to {
+			hi = to
+		}
+		if lo >= hi {
+			break
+		}
+		wg.Add(1)
+		go func(i int, lo int64, mem []byte) {
+			defer wg.Done()
+			faulted[i], errs[i] = prefault(mem, write)
+			errs[i] = m.faultError(errs[i], lo)
+		}(i, lo, m.pages(lo, hi))
+	}
+	wg.Wait()
+	var total int64
+	for i, err := range errs {
+		if ferr, ok := err.(*FaultError); ok {
+			return 0, 0, ferr
+		}
+		if err != nil {
+			return 0, 0, m.wrap("prefault", off, n, err)
+		}
+		total += faulted[i]
+	}
+	return total, time.Since(begin), nil
+}
+
+// Fault in the pages of a memory region, returning how many of them were not resident before
+func prefault(mem []byte, write bool) (int64, error) {
+	vec := make([]byte, (int64(len(mem))+pageSize-1)/pageSize)
+	err := mincore(mem, vec)
+	if err != nil {
+		return 0, err
+	}
+	var faulted int64
+	for _, v := range vec {
+		if v&1 == 0 {
+			faulted++
+		}
+	}
+	if !noPopulate.Load() {
+		advice := MADV_POPULATE_READ
+		if write {
+			advice = MADV_POPULATE_WRITE
+		}
+		err = madvise(mem, advice)
+		if err != syscall.EINVAL {
+			return faulted, err
+		}
+		noPopulate.Store(true)
+	}
+	err = guard(func() {
+		for i := 0; i < len(mem); i += int(pageSize) {
+			// Atomic accesses are not optimized away and a write of nothing races with no other writer
+			p := (*uint32)(unsafe.Pointer(&mem[i]))
+			if write {
+				atomic.AddUint32(p, 0)
+			} else {
+				atomic.LoadUint32(p)
+			}
+		}
+	})
+	return faulted, err
+}
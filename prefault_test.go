@@ -0,0 +1,97 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPrefault(t *testing.T) {
+	page := int64(os.Getpagesize())
+	for _, fallback := range []bool{false, true} {
+		noPopulate.Store(fallback)
+		m, err := NewAnonymous(16 * page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, _, err := m.PrefaultParallel(page+1, 8*page, true, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pages != 9 {
+			t.Error("wrong number of faulted pages", pages, "fallback", fallback)
+		}
+		r, err := m.Resident(0, m.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs := r.Runs()
+		if len(runs) != 1 || runs[0] != (Range{page, 9 * page}) {
+			t.Error("wrong resident pages after prefault", runs, "fallback", fallback)
+		}
+		pages, _, err = m.Prefault(0, m.Size(), false)
+		if err != nil || pages != 7 {
+			t.Error("resident pages counted as faulted", pages, err, "fallback", fallback)
+		}
+		m.Close()
+	}
+	noPopulate.Store(false)
+}
+
+func TestPrefaultReadOnly(t *testing.T) {
+	name, err := rndfile(4 * os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	_, _, err = m.Prefault(0, m.Size(), false)
+	if err != nil {
+		t.Error("failed to prefault read-only mapping", err)
+	}
+	_, _, err = m.Prefault(0, m.Size(), true)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("prefaulted read-only mapping for writing", err)
+	}
+}
+
+func TestPrefaultTruncated(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name, err := rndfile(int(4 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	// Truncate the file underneath the mapping
+	err = os.Truncate(name, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = m.Prefault(0, m.Size(), false)
+	if err == nil {
+		t.Error("prefaulted pages past the end of file")
+	}
+	noPopulate.Store(true)
+	defer noPopulate.Store(false)
+	_, _, err = m.Prefault(0, m.Size(), false)
+	var ferr *FaultError
+	if !errors.As(err, &ferr) || ferr.Off != page {
+		t.Error("touching pages past the end of file did not fault", err)
+	}
+}
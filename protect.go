@@ -0,0 +1,88 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Mprotect changes the page protection of n bytes of the mapping starting at file offset off to prot,
+// a combination of PROT_* values. off must be page aligned and n is rounded up to a multiple of the
+// page size. Write and WriteAt fail with ErrReadOnly on ranges that are not writable, instead of
+// faulting. The protection is kept when the mapping grows or moves. Windowed mappings are not supported.
+func (m *Mmap) Mprotect(off, n int64, prot int) error {
+	m.Lock()
+	defer m.Unlock()
+	from, to, err := m.pageSpan("mprotect", off, n)
+	if err != nil || from == to {
+		return err
+	}
+	err = mprotect(m.pages(from, to), prot)
+	if err != nil {
+		return m.wrap("mprotect", off, n, err)
+	}
+	m.protect = setRange(m.protect, from, to, prot, prot != m.protection())
+	return nil
+}
+
+// Report whether n bytes at file offset off can be written, the mapping must be locked
+func (m *Mmap) writable(off, n int64) bool {
+	return m.allows(off, n, PROT_WRITE)
+}
+
+// Report whether the protection of n bytes at file offset off includes prot, the mapping must be locked
+func (m *Mmap) allows(off, n int64, prot int) bool {
+	base := m.protection()&prot == prot
+	pos := off
+	for _, r := range m.protect {
+		if r.Off+r.Len <= pos || r.Off >= off+n {
+			continue
+		}
+		if (r.Off > pos && !base) || r.attr&prot != prot {
+			return false
+		}
+		pos = r.Off + r.Len
+	}
+	return pos >= off+n || base
+}
+
+// Apply the protection set by Mprotect again after the mapping was mapped or grown,
+// dropping the ranges that are not mapped any more
+func (m *Mmap) reprotect() error {
+	m.protect = m.clipRanges(m.protect)
+	for _, r := range m.protect {
+		err := mprotect(m.pages(r.Off, r.Off+r.Len), r.attr)
+		if err != nil {
+			return m.wrap("mprotect", r.Off, r.Len, err)
+		}
+	}
+	return nil
+}
+
+// Give the whole mapping the same protection, mremap fails on mappings split by mprotect
+func (m *Mmap) unprotect() error {
+	if len(m.protect) == 0 || m.mem == nil {
+		return nil
+	}
+	err := mprotect(m.mem, m.protection())
+	if err != nil {
+		return m.wrap("mprotect", m.start&^(pageSize-1), int64(len(m.mem)), err)
+	}
+	return nil
+}
+
+// Change the protection of a memory region
+func mprotect(mem []byte, prot int) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MPROTECT, uintptr(addr), uintptr(len(mem)), uintptr(prot))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMprotect(t *testing.T) {
+	page := int64(os.Getpagesize())
+	name := tmpname()
+	m, err := Create(name, 4*page, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	err = m.Mprotect(page+1, page, PROT_READ)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("allowed to protect an unaligned range", err)
+	}
+	err = m.Mprotect(page, page, PROT_READ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := rndmessage(100)
+	_, err = m.WriteAt(msg, page+10)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("allowed to write to a read-only range", err)
+	}
+	_, err = m.WriteAt(msg, page-50)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("allowed to write across a read-only range", err)
+	}
+	_, err = m.WriteAt(msg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Grow the mapping so it is moved by mremap
+	_, err = m.WriteAt(msg, 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Do(func(b []byte) error {
+		b[page+10] = 'x'
+		return nil
+	})
+	if !errors.Is(err, ErrFault) {
+		t.Error("protection not applied again after growing", err)
+	}
+	err = m.Mprotect(page, page, PROT_READ|PROT_WRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.WriteAt(msg, page+10)
+	if err != nil {
+		t.Error("failed to write after restoring protection", err)
+	}
+	// Private mappings move to anonymous memory when they grow
+	name, err = rndfile(int(4 * page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	p, err := OpenPrivate(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.Mprotect(0, page, PROT_READ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Mprotect(page, page, PROT_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.WriteAt(msg, 64*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.WriteAt(msg, 10)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("protection of private mapping not applied again after growing", err)
+	}
+	err = p.Do(func(b []byte) error {
+		b[page+10] = 'x'
+		return nil
+	})
+	if !errors.Is(err, ErrFault) {
+		t.Error("protection of private mapping not applied again after growing", err)
+	}
+}
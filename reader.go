@@ -0,0 +1,162 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import "io"
+
+// Reader is a read cursor over a mapping with its own offset, so goroutines streaming the same
+// mapping do not share, or serialize on, the offset of Mmap. Readers of a mapping only take its
+// read lock, except for windowed mappings where every read may have to slide the window.
+// A Reader is not safe for concurrent use, create one per goroutine.
+type Reader struct {
+	m     *Mmap
+	base  int64 // file offset of offset 0 of the reader
+	limit int64 // file offset of the end of the section, -1 to follow the end of file
+	off   int64 // file offset of the next Read
+}
+
+// NewReader returns a Reader that reads the mapping from the start of the mapped range to the end of file.
+// Offsets of the Reader are file offsets, like the offsets of Mmap.
+func (m *Mmap) NewReader() *Reader {
+	m.RLock()
+	start, _ := m.bounds()
+	m.RUnlock()
+	return &Reader{m: m, limit: -1, off: start}
+}
+
+// NewSectionReader returns a Reader that reads n bytes of the mapping starting at file offset off.
+// Like io.SectionReader, offsets of the Reader are relative to off.
+func (m *Mmap) NewSectionReader(off, n int64) *Reader {
+	limit := off + n
+	if n < 0 {
+		limit = off
+	}
+	return &Reader{m: m, base: off, limit: limit, off: off}
+}
+
+// Read reads up to len(b) bytes and advances the offset of the Reader.
+// At the end of the section or of the file, Read returns 0, io.EOF.
+func (r *Reader) Read(b []byte) (n int, err error) {
+	n, err = r.readAt(b, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(b) bytes starting at offset off of the Reader, without changing its offset.
+// ReadAt always returns a non-nil error when n < len(b). At end of file, that error is io.EOF.
+func (r *Reader) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, r.m.wrap("read", off, int64(len(b)), ErrNegativePosition)
+	}
+	n, err = r.readAt(b, r.base+off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read, interpreted according to whence like Mmap.Seek does.
+// Seeking past the end is allowed, a following Read returns io.EOF.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case SEEK_SET:
+		abs = r.base + offset
+	case SEEK_CUR:
+		abs = r.off + offset
+	case SEEK_END:
+		end := r.limit
+		if end < 0 {
+			r.m.RLock()
+			_, end = r.m.bounds()
+			r.m.RUnlock()
+		}
+		abs = end + offset
+	default:
+		return 0, r.m.wrap("seek", 0, 0, ErrInvalidWhence)
+	}
+	if abs < r.base {
+		return 0, r.m.wrap("seek", 0, 0, ErrNegativePosition)
+	}
+	r.off = abs
+	return abs - r.base, nil
+}
+
+// WriteTo writes the mapped bytes from the offset of the Reader up to the end of the section or of
+// the file to w, without copying them to an intermediate buffer. Shared mappings are sent with sendfile
+// when w is backed by a file descriptor. The mapping is locked while w writes, so w must not call
+// methods of the mapping that change it.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	m := r.m
+	if m.window > 0 {
+		m.Lock()
+		defer m.Unlock()
+	} else {
+		m.RLock()
+		defer m.RUnlock()
+	}
+	if m.closed {
+		return 0, m.wrap("read", r.off, 0, ErrClosed)
+	}
+	start, end := r.span()
+	if r.off < start {
+		return 0, m.wrap("read", r.off, 0, ErrOutOfRange)
+	}
+	if r.off >= end {
+		return 0, nil
+	}
+	n, err = m.writeTo(w, r.off, end)
+	r.off += n
+	return n, err
+}
+
+// Read at file offset off, stopping at the end of the section or of the file
+func (r *Reader) readAt(b []byte, off int64) (n int, err error) {
+	m := r.m
+	if m.window > 0 {
+		m.Lock()
+		defer m.Unlock()
+	} else {
+		m.RLock()
+		defer m.RUnlock()
+	}
+	if m.closed {
+		return 0, m.wrap("read", off, int64(len(b)), ErrClosed)
+	}
+	start, end := r.span()
+	if off < start {
+		return 0, m.wrap("read", off, int64(len(b)), ErrOutOfRange)
+	}
+	if off >= end {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > end-off {
+		b = b[:end-off]
+	}
+	if m.window > 0 {
+		return m.windowRead(b, off)
+	}
+	return m.copyOut(b, off)
+}
+
+// Return the range of file offsets the reader can access, the mapping must be locked
+func (r *Reader) span() (start, end int64) {
+	start, end = r.m.bounds()
+	if r.limit >= 0 {
+		if r.base > start {
+			start = r.base
+		}
+		if r.limit < end {
+			end = r.limit
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	size := os.Getpagesize() * 4
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := io.ReadAll(m.NewReader())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(b, data) {
+				t.Error("wrong data read by concurrent reader")
+			}
+		}()
+	}
+	wg.Wait()
+	if m.Offset() != 0 {
+		t.Error("readers moved the offset of the mapping")
+	}
+	off, n := int64(os.Getpagesize()-10), int64(100)
+	r := m.NewSectionReader(off, n)
+	var buf bytes.Buffer
+	c, err := io.Copy(&buf, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != n || !bytes.Equal(buf.Bytes(), data[off:off+n]) {
+		t.Error("wrong data written by section reader")
+	}
+	pos, err := r.Seek(-10, SEEK_END)
+	if err != nil || pos != n-10 {
+		t.Error("wrong position of section reader", pos, err)
+	}
+	b := make([]byte, 20)
+	k, err := r.Read(b)
+	if err != nil || k != 10 || !bytes.Equal(b[:k], data[off+n-10:off+n]) {
+		t.Error("wrong data read at the end of section", k, err)
+	}
+	_, err = r.ReadAt(b, n-5)
+	if err != io.EOF {
+		t.Error("expected EOF reading past the end of section", err)
+	}
+	done := make(chan error)
+	m.RLock()
+	go func() {
+		_, err := m.NewReader().Read(b)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("reader blocked by a concurrent reader")
+	}
+	m.RUnlock()
+}
+
+func TestWindowReader(t *testing.T) {
+	size := os.Getpagesize() * 10
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenWindow(name, os.O_RDONLY, 0644, int64(2*os.Getpagesize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var buf bytes.Buffer
+	_, err = m.NewReader().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("wrong data written across windows")
+	}
+}
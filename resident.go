@@ -0,0 +1,104 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Residency reports which pages of a range of the mapping are resident in memory.
+type Residency struct {
+	Off      int64  // page aligned file offset of the first page
+	PageSize int64  // size of the pages
+	Pages    []bool // residency of every page, true when it is in memory
+}
+
+// Resident returns the residency of the pages holding n bytes of the mapping starting at file offset off,
+// as reported by mincore(2). For shared file mappings a page counts as resident when it is in the
+// page cache, even if the mapping never accessed it. Windowed mappings are not supported.
+func (m *Mmap) Resident(off, n int64) (*Residency, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return nil, m.wrap("mincore", off, n, ErrClosed)
+	}
+	if m.window > 0 {
+		return nil, m.wrap("mincore", off, n, ErrUnsupported)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end {
+		return nil, m.wrap("mincore", off, n, ErrOutOfRange)
+	}
+	from := off &^ (pageSize - 1)
+	to := (off + n + pageSize - 1) &^ (pageSize - 1)
+	r := &Residency{Off: from, PageSize: pageSize, Pages: make([]bool, (to-from)/pageSize)}
+	if n == 0 {
+		return r, nil
+	}
+	vec := make([]byte, len(r.Pages))
+	err := mincore(m.pages(from, to), vec)
+	if err != nil {
+		return nil, m.wrap("mincore", off, n, err)
+	}
+	for i, v := range vec {
+		r.Pages[i] = v&1 != 0
+	}
+	return r, nil
+}
+
+// ResidentPages returns the number of resident pages.
+func (r *Residency) ResidentPages() int {
+	var c int
+	for _, p := range r.Pages {
+		if p {
+			c++
+		}
+	}
+	return c
+}
+
+// ResidentBytes returns the size of the resident pages.
+func (r *Residency) ResidentBytes() int64 {
+	return int64(r.ResidentPages()) * r.PageSize
+}
+
+// Percent returns the percentage of resident pages, 100 for an empty range.
+func (r *Residency) Percent() float64 {
+	if len(r.Pages) == 0 {
+		return 100
+	}
+	return float64(r.ResidentPages()) * 100 / float64(len(r.Pages))
+}
+
+// Runs returns the file ranges of contiguous resident pages, sorted by offset.
+func (r *Residency) Runs() []Range {
+	var runs []Range
+	for i := 0; i < len(r.Pages); i++ {
+		if !r.Pages[i] {
+			continue
+		}
+		j := i
+		for j < len(r.Pages) && r.Pages[j] {
+			j++
+		}
+		runs = append(runs, Range{Off: r.Off + int64(i)*r.PageSize, Len: int64(j-i) * r.PageSize})
+		i = j
+	}
+	return runs
+}
+
+// Report the residency of the pages of a memory region in vec
+func mincore(mem []byte, vec []byte) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MINCORE, uintptr(addr), uintptr(len(mem)), uintptr(unsafe.Pointer(unsafe.SliceData(vec))))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestResident(t *testing.T) {
+	page := int64(os.Getpagesize())
+	m, err := NewAnonymous(8 * page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	r, err := m.Resident(0, m.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Pages) != 8 || r.ResidentPages() != 0 {
+		t.Error("untouched anonymous pages reported resident", r.Pages)
+	}
+	_, err = m.WriteAt([]byte("test"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.WriteAt(make([]byte, 2*page), 4*page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = m.Resident(5, m.Size()-5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ResidentBytes() != 3*page || r.Percent() != 37.5 {
+		t.Error("wrong resident size", r.ResidentBytes(), r.Percent())
+	}
+	runs := r.Runs()
+	if len(runs) != 2 || runs[0] != (Range{0, page}) || runs[1] != (Range{4 * page, 2 * page}) {
+		t.Error("wrong resident runs", runs)
+	}
+	_, err = m.Resident(page, m.Size())
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to query past the end of the mapping", err)
+	}
+}
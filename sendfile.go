@@ -0,0 +1,259 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	maxSendfile   = 1 << 30   // maximum bytes transferred by a single sendfile call
+	readFromChunk = 64 * 1024 // bytes the mapping grows by for every read of ReadFrom
+)
+
+// SendTo sends n bytes of the mapping starting at file offset off to conn, usually a socket, without
+// copying them through user space buffers. Shared mappings are sent from the file with sendfile,
+// private mappings are spliced from memory with vmsplice, so their bytes must not change until
+// the peer received them. When neither is supported by conn the mapped bytes are written to it.
+// SendTo returns the number of bytes sent.
+func (m *Mmap) SendTo(conn syscall.Conn, off, n int64) (int64, error) {
+	if m.window > 0 {
+		m.Lock()
+		defer m.Unlock()
+	} else {
+		m.RLock()
+		defer m.RUnlock()
+	}
+	if m.closed {
+		return 0, m.wrap("send", off, n, ErrClosed)
+	}
+	start, end := m.bounds()
+	if n < 0 || off < start || off+n > end {
+		return 0, m.wrap("send", off, n, ErrOutOfRange)
+	}
+	var sent int64
+	var handled bool
+	var err error
+	if m.private {
+		sent, handled, err = m.vmsplice(conn, off, n)
+	} else {
+		sent, handled, err = m.sendfile(conn, off, n)
+	}
+	if handled {
+		return sent, err
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, m.wrap("send", off, n, err)
+	}
+	return m.writeTo(rawWriter{rc}, off, off+n)
+}
+
+// Write the mapped bytes between file offsets off and end to w, the mapping must be locked.
+// Shared mappings are sent with sendfile when w is backed by a file descriptor.
+func (m *Mmap) writeTo(w io.Writer, off, end int64) (n int64, err error) {
+	if conn, ok := w.(syscall.Conn); ok && !m.private {
+		var handled bool
+		n, handled, err = m.sendfile(conn, off, end-off)
+		if handled {
+			return n, err
+		}
+	}
+	for off+n < end {
+		pos := off + n
+		if m.window > 0 {
+			err = m.slide(pos)
+			if err != nil {
+				return n, err
+			}
+		}
+		stop := end
+		if e := m.start + int64(len(m.Data)); e < stop {
+			stop = e
+		}
+		var c int
+		c, err = m.writeOut(w, m.Data[pos-m.start:stop-m.start], pos)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write mapped bytes starting at file offset off to w, turning memory faults into errors
+func (m *Mmap) writeOut(w io.Writer, b []byte, off int64) (n int, err error) {
+	ferr := guard(func() {
+		n, err = w.Write(b)
+	})
+	if ferr != nil {
+		return 0, m.faultError(ferr, off)
+	}
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// Send n bytes of the file starting at file offset off to conn with sendfile(2), without copying them
+// through user space. handled is false when conn does not support sendfile and nothing was sent.
+func (m *Mmap) sendfile(conn syscall.Conn, off, n int64) (written int64, handled bool, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	src := int(m.fd.Fd())
+	var serr error
+	err = rc.Write(func(fd uintptr) bool {
+		for written < n {
+			pos := off + written
+			count := n - written
+			if count > maxSendfile {
+				count = maxSendfile
+			}
+			c, errno := syscall.Sendfile(int(fd), src, &pos, int(count))
+			if c > 0 {
+				written += int64(c)
+			}
+			switch {
+			case errno == syscall.EAGAIN:
+				// Wait until the socket is writable
+				return false
+			case errno == syscall.EINTR:
+			case errno != nil:
+				serr = errno
+				return true
+			case c == 0:
+				// The file was truncated underneath the mapping
+				serr = io.ErrUnexpectedEOF
+				return true
+			}
+		}
+		return true
+	})
+	if written == 0 && unsupported(serr) {
+		return 0, false, nil
+	}
+	if serr != nil {
+		return written, true, m.wrap("sendfile", off+written, n-written, serr)
+	}
+	return written, true, err
+}
+
+// Splice n bytes of the mapping starting at file offset off to conn through a pipe, vmsplice(2) moves
+// the pages of the mapping to the pipe and splice(2) moves them on to conn. handled is false when
+// conn does not support splice and nothing was sent.
+func (m *Mmap) vmsplice(conn syscall.Conn, off, n int64) (sent int64, handled bool, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	var p [2]int
+	err = syscall.Pipe2(p[:], syscall.O_CLOEXEC)
+	if err != nil {
+		return 0, false, nil
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+	for sent < n {
+		count := n - sent
+		if count > maxSendfile {
+			count = maxSendfile
+		}
+		b := m.Data[off+sent-m.start : off+sent-m.start+count]
+		iov := syscall.Iovec{Base: unsafe.SliceData(b)}
+		iov.SetLen(len(b))
+		// Only as much as fits in the pipe is moved, the pipe is drained before the next call
+		c, _, errno := syscall.Syscall6(SYS_VMSPLICE, uintptr(p[1]), uintptr(unsafe.Pointer(&iov)), 1, SPLICE_F_NONBLOCK, 0, 0)
+		if errno != 0 {
+			if sent == 0 && unsupported(errno) {
+				return 0, false, nil
+			}
+			return sent, true, m.wrap("vmsplice", off+sent, n-sent, errno)
+		}
+		left := int(c)
+		var serr error
+		err = rc.Write(func(fd uintptr) bool {
+			for left > 0 {
+				c, errno := syscall.Splice(p[0], nil, int(fd), nil, left, SPLICE_F_MOVE|SPLICE_F_NONBLOCK)
+				if c > 0 {
+					left -= int(c)
+					sent += int64(c)
+				}
+				switch {
+				case errno == syscall.EAGAIN:
+					// Wait until the socket is writable
+					return false
+				case errno == syscall.EINTR:
+				case errno != nil:
+					serr = errno
+					return true
+				}
+			}
+			return true
+		})
+		if serr != nil {
+			if sent == 0 && unsupported(serr) {
+				return 0, false, nil
+			}
+			return sent, true, m.wrap("splice", off+sent, n-sent, serr)
+		}
+		if err != nil {
+			return sent, true, err
+		}
+	}
+	return sent, true, nil
+}
+
+// Report whether a system call failed because the descriptors do not support it
+func unsupported(err error) bool {
+	return err == syscall.EINVAL || err == syscall.ENOSYS || err == syscall.EOPNOTSUPP
+}
+
+// Writes to the file descriptor of a syscall.Conn, waiting for non-blocking descriptors to become writable
+type rawWriter struct {
+	rc syscall.RawConn
+}
+
+func (w rawWriter) Write(b []byte) (n int, err error) {
+	var werr error
+	err = w.rc.Write(func(fd uintptr) bool {
+		for n < len(b) {
+			c, errno := syscall.Write(int(fd), b[n:])
+			if c > 0 {
+				n += c
+			}
+			switch {
+			case errno == syscall.EAGAIN:
+				return false
+			case errno == syscall.EINTR:
+			case errno != nil:
+				werr = errno
+				return true
+			}
+		}
+		return true
+	})
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// Return the number of bytes r is known to hold, 0 if unknown
+func sizeHint(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *io.LimitedReader:
+		return r.N
+	case interface{ Len() int }:
+		return int64(r.Len())
+	}
+	return 0
+}
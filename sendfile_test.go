@@ -0,0 +1,218 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Hide the size and the methods of a reader
+type plainReader struct {
+	r io.Reader
+}
+
+func (p plainReader) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func TestReadFrom(t *testing.T) {
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	msg := rndmessage(3*os.Getpagesize() + 100)
+	n, err := m.ReadFrom(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) || m.Size() != n || m.Offset() != n {
+		t.Error("wrong size after reading", n, m.Size())
+	}
+	more := rndmessage(200 * 1024)
+	n, err = io.Copy(m, plainReader{bytes.NewReader(more)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(more)) {
+		t.Error("wrong number of bytes copied", n)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, append(msg, more...)) {
+		t.Error("wrong data in file after reading")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	size := 3*os.Getpagesize() + 100
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []bool{false, true} {
+		m, err := Open(name, WithFlag(os.O_RDWR))
+		if private {
+			m, err = OpenPrivate(name, os.O_RDONLY, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.Seek(100, SEEK_SET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := tmpname()
+		f, err := os.Create(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(f, m)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(size-100) || m.Offset() != int64(size) {
+			t.Error("wrong number of bytes written", n)
+		}
+		b, err := os.ReadFile(out)
+		os.Remove(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[100:]) {
+			t.Error("wrong data written to file, private:", private)
+		}
+		var buf bytes.Buffer
+		_, err = m.Seek(0, SEEK_SET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Error("wrong data written to buffer, private:", private)
+		}
+		m.Close()
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	size := 100*os.Getpagesize() + 100
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("loopback listener not available:", err)
+	}
+	defer ln.Close()
+	for _, private := range []bool{false, true} {
+		m, err := OpenFile(name, os.O_RDONLY, 0644)
+		if private {
+			m, err = OpenPrivate(name, os.O_RDONLY, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan []byte)
+		go func() {
+			c, err := ln.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			b, _ := io.ReadAll(c)
+			c.Close()
+			received <- b
+		}()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, n := int64(100), int64(size-200)
+		sent, err := m.SendTo(conn.(*net.TCPConn), off, n)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := <-received
+		if sent != n || !bytes.Equal(b, data[off:off+n]) {
+			t.Error("wrong data sent over loopback, private:", private, sent, len(b))
+		}
+		_, err = m.SendTo(conn.(*net.TCPConn), off, int64(size))
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Error("allowed to send past the end of the mapping", err)
+		}
+		m.Close()
+	}
+}
+
+func TestSendToFallback(t *testing.T) {
+	size := 4*os.Getpagesize() + 100
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	out := tmpname()
+	defer os.Remove(out)
+	// sendfile and splice do not support files opened with O_APPEND
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn syscall.Conn = f
+	sent, err := m.SendTo(conn, 0, int64(size))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != int64(size) || !bytes.Equal(b, data) {
+		t.Error("wrong data written by fallback")
+	}
+}
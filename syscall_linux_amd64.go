@@ -11,12 +11,27 @@
 package yammap
 
 const (
-	SYS_MMAP      = 9
-	SYS_MREMAP    = 25
-	SYS_MUNMAP    = 11
-	SYS_MSYNC     = 26
-	SYS_FTRUNCATE = 77
-	SYS_MADVISE   = 28
+	SYS_MMAP         = 9
+	SYS_MREMAP       = 25
+	SYS_MUNMAP       = 11
+	SYS_MSYNC        = 26
+	SYS_FTRUNCATE    = 77
+	SYS_MADVISE      = 28
+	SYS_MLOCK        = 149
+	SYS_VMSPLICE     = 278
+	SYS_MEMFD_CREATE = 319
+	SYS_FCNTL        = 72
+	SYS_MPROTECT     = 10
+	SYS_MUNLOCK      = 150
+	SYS_MLOCK2       = 325
+	SYS_MINCORE      = 27
 
-	maxSize = (1 << 47) - 1 // maximum allocation size, 128TiB for x86_64
+	MAP_ANONYMOUS  = 0x20    // mapping is not backed by a file
+	RLIMIT_MEMLOCK = 0x8     // maximum bytes of memory locked to RAM
+	MAP_LOCKED     = 0x2000  // pages are locked to RAM
+	MAP_POPULATE   = 0x8000  // populate (prefault) pagetables
+	MAP_HUGETLB    = 0x40000 // mapping is backed by hugetlb pages
+
+	mmapOffsetShift = 0             // mmap takes the file offset in bytes
+	maxSize         = (1 << 47) - 1 // maximum allocation size, 128TiB for x86_64
 )
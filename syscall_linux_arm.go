@@ -11,12 +11,27 @@
 package yammap
 
 const (
-	SYS_MMAP      = 192
-	SYS_MREMAP    = 163
-	SYS_MUNMAP    = 91
-	SYS_MSYNC     = 144
-	SYS_FTRUNCATE = 93
-	SYS_MADVISE   = 220
+	SYS_MMAP         = 192
+	SYS_MREMAP       = 163
+	SYS_MUNMAP       = 91
+	SYS_MSYNC        = 144
+	SYS_FTRUNCATE    = 93
+	SYS_MADVISE      = 220
+	SYS_MLOCK        = 150
+	SYS_VMSPLICE     = 343
+	SYS_MEMFD_CREATE = 385
+	SYS_FCNTL        = 55
+	SYS_MPROTECT     = 125
+	SYS_MUNLOCK      = 151
+	SYS_MLOCK2       = 390
+	SYS_MINCORE      = 219
 
-	maxSize = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
+	MAP_ANONYMOUS  = 0x20    // mapping is not backed by a file
+	RLIMIT_MEMLOCK = 0x8     // maximum bytes of memory locked to RAM
+	MAP_LOCKED     = 0x2000  // pages are locked to RAM
+	MAP_POPULATE   = 0x8000  // populate (prefault) pagetables
+	MAP_HUGETLB    = 0x40000 // mapping is backed by hugetlb pages
+
+	mmapOffsetShift = 12            // mmap2 takes the file offset in 4096 byte units
+	maxSize         = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
 )
@@ -11,12 +11,27 @@
 package yammap
 
 const (
-	SYS_MMAP      = 4090
-	SYS_MREMAP    = 4167
-	SYS_MUNMAP    = 4091
-	SYS_MSYNC     = 4144
-	SYS_FTRUNCATE = 4212
-	SYS_MADVISE   = 4218
+	SYS_MMAP         = 4210 // Using mmap2
+	SYS_MREMAP       = 4167
+	SYS_MUNMAP       = 4091
+	SYS_MSYNC        = 4144
+	SYS_FTRUNCATE    = 4212
+	SYS_MADVISE      = 4218
+	SYS_MLOCK        = 4154
+	SYS_VMSPLICE     = 4307
+	SYS_MEMFD_CREATE = 4354
+	SYS_FCNTL        = 4055
+	SYS_MPROTECT     = 4125
+	SYS_MUNLOCK      = 4155
+	SYS_MLOCK2       = 4359
+	SYS_MINCORE      = 4217
 
-	maxSize = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
+	MAP_ANONYMOUS  = 0x800   // mapping is not backed by a file
+	RLIMIT_MEMLOCK = 0x9     // maximum bytes of memory locked to RAM
+	MAP_LOCKED     = 0x8000  // pages are locked to RAM
+	MAP_POPULATE   = 0x10000 // populate (prefault) pagetables
+	MAP_HUGETLB    = 0x80000 // mapping is backed by hugetlb pages
+
+	mmapOffsetShift = 12            // mmap2 takes the file offset in 4096 byte units
+	maxSize         = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
 )
@@ -11,12 +11,27 @@
 package yammap
 
 const (
-	SYS_MMAP      = 5009
-	SYS_MREMAP    = 5024
-	SYS_MUNMAP    = 5011
-	SYS_MSYNC     = 5025
-	SYS_FTRUNCATE = 5075
-	SYS_MADVISE   = 5027
+	SYS_MMAP         = 5009
+	SYS_MREMAP       = 5024
+	SYS_MUNMAP       = 5011
+	SYS_MSYNC        = 5025
+	SYS_FTRUNCATE    = 5075
+	SYS_MADVISE      = 5027
+	SYS_MLOCK        = 5146
+	SYS_VMSPLICE     = 5266
+	SYS_MEMFD_CREATE = 5314
+	SYS_FCNTL        = 5070
+	SYS_MPROTECT     = 5010
+	SYS_MUNLOCK      = 5147
+	SYS_MLOCK2       = 5319
+	SYS_MINCORE      = 5026
 
-	maxSize = (1 << 47) - 1 // maximum allocation size, 128TiB for 64bit CPUs
+	MAP_ANONYMOUS  = 0x800   // mapping is not backed by a file
+	RLIMIT_MEMLOCK = 0x9     // maximum bytes of memory locked to RAM
+	MAP_LOCKED     = 0x8000  // pages are locked to RAM
+	MAP_POPULATE   = 0x10000 // populate (prefault) pagetables
+	MAP_HUGETLB    = 0x80000 // mapping is backed by hugetlb pages
+
+	mmapOffsetShift = 0             // mmap takes the file offset in bytes
+	maxSize         = (1 << 47) - 1 // maximum allocation size, 128TiB for 64bit CPUs
 )
@@ -11,12 +11,27 @@
 package yammap
 
 const (
-	SYS_MMAP      = 222
-	SYS_MREMAP    = 216
-	SYS_MUNMAP    = 215
-	SYS_MSYNC     = 227
-	SYS_FTRUNCATE = 46
-	SYS_MADVISE   = 233
+	SYS_MMAP         = 222
+	SYS_MREMAP       = 216
+	SYS_MUNMAP       = 215
+	SYS_MSYNC        = 227
+	SYS_FTRUNCATE    = 46
+	SYS_MADVISE      = 233
+	SYS_MLOCK        = 228
+	SYS_VMSPLICE     = 75
+	SYS_MEMFD_CREATE = 279
+	SYS_FCNTL        = 25
+	SYS_MPROTECT     = 226
+	SYS_MUNLOCK      = 229
+	SYS_MLOCK2       = 284
+	SYS_MINCORE      = 232
 
-	maxSize = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
+	MAP_ANONYMOUS  = 0x20    // mapping is not backed by a file
+	RLIMIT_MEMLOCK = 0x8     // maximum bytes of memory locked to RAM
+	MAP_LOCKED     = 0x2000  // pages are locked to RAM
+	MAP_POPULATE   = 0x8000  // populate (prefault) pagetables
+	MAP_HUGETLB    = 0x40000 // mapping is backed by hugetlb pages
+
+	mmapOffsetShift = 12            // mmap2 takes the file offset in 4096 byte units
+	maxSize         = (1 << 31) - 1 // maximum allocation size, 2GiB for 32bit CPUs
 )
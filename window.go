@@ -0,0 +1,92 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import "io"
+
+// OpenWindow opens or creates the named file as memory-mapped through a sliding window of the given size.
+// Only the window is mapped at any time and Read, ReadAt, Write, WriteAt and Seek move it over the file
+// as needed, so files bigger than the address space of the CPU can be accessed. The window size is rounded
+// up to a multiple of the page size. Data holds the current window.
+func OpenWindow(name string, flag int, perm uint32, window int64) (*Mmap, error) {
+	if window <= 0 {
+		return nil, &Error{Op: "open", Path: name, Err: ErrInvalidRange}
+	}
+	return Open(name, WithFlag(flag), WithPerm(perm), WithWindow(window))
+}
+
+// Move the window so that it covers file offset off
+func (m *Mmap) slide(off int64) error {
+	start := off - off%m.window
+	length := m.window
+	if m.size-start < length {
+		length = m.size - start
+	}
+	if m.mem != nil && m.start == start && int64(len(m.Data)) == length {
+		return nil
+	}
+	mem := m.mem
+	err := m.mmap(start, length)
+	if err != nil {
+		return err
+	}
+	return unmap(mem)
+}
+
+// Read from file offset off, sliding the window over the file
+func (m *Mmap) windowRead(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, m.wrap("read", off, int64(len(b)), ErrNegativePosition)
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > m.size-off {
+		b = b[:m.size-off]
+	}
+	for n < len(b) {
+		err = m.slide(off + int64(n))
+		if err != nil {
+			return n, err
+		}
+		var c int
+		c, err = m.copyOut(b[n:], off+int64(n))
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write at file offset off, growing the file and sliding the window over it
+func (m *Mmap) windowWrite(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, m.wrap("write", off, int64(len(b)), ErrNegativePosition)
+	}
+	if end := off + int64(len(b)); end > m.size {
+		err = m.truncate(end)
+		if err != nil {
+			return 0, err
+		}
+		m.size = end
+	}
+	for n < len(b) {
+		err = m.slide(off + int64(n))
+		if err != nil {
+			return n, err
+		}
+		var c int
+		c, err = m.copyIn(off+int64(n), b[n:])
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
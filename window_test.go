@@ -0,0 +1,97 @@
+/*
+	Copyright (C) 2022, Lefteris Zafiris <zaf@fastmail.com>
+	This program is free software, distributed under the terms of
+	the GNU GPL v3 License. See the LICENSE file
+	at the top of the source tree.
+*/
+
+package yammap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestWindowReadWrite(t *testing.T) {
+	size := os.Getpagesize() * 10
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := int64(2 * os.Getpagesize())
+	m, err := OpenWindow(name, os.O_RDWR, 0644, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Size() != int64(size) {
+		t.Error("wrong size of windowed file")
+	}
+	b := make([]byte, size)
+	n, err := m.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size || !bytes.Equal(b, data) {
+		t.Error("wrong data read across windows")
+	}
+	if int64(len(m.Data)) > window {
+		t.Error("mapped more than the window size")
+	}
+	off := int64(3*os.Getpagesize() - 10)
+	b = make([]byte, 3*os.Getpagesize())
+	_, err = m.ReadAt(b, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data[off:off+int64(len(b))]) {
+		t.Error("wrong data read at offset")
+	}
+	msg := rndmessage(os.Getpagesize() * 5)
+	_, err = m.WriteAt(msg, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Seek(0, SEEK_END)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != int64(size+len(msg)) {
+		t.Error("wrong size after growing")
+	}
+	_, err = m.ReadAt(b, m.Size()-10)
+	if err != io.EOF {
+		t.Error("expected EOF reading past the end of file")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[off:], msg)
+	data = append(data, msg...)
+	b, err = os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Error("wrong data written across windows")
+	}
+	err = m.Truncate(int64(os.Getpagesize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != int64(os.Getpagesize()) {
+		t.Error("wrong size after truncating")
+	}
+}
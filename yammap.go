@@ -13,99 +13,186 @@ package yammap
 
 import (
 	"errors"
-	"fmt"
 	"io"
+	"math"
 	"os"
-	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
 
+var pageSize = int64(os.Getpagesize())
+
 // Mmap holds our in-memory file data
 type Mmap struct {
 	sync.RWMutex
-	fd     *os.File
-	flag   int
-	offset int64
-	Data   []byte
-	append bool
+	fd         *os.File
+	flag       int
+	offset     int64
+	Data       []byte
+	append     bool
+	mem        []byte       // page aligned mapping that holds Data
+	start      int64        // file offset of Data[0]
+	ranged     bool         // only a fixed window of the file is mapped
+	window     int64        // size of the sliding window, 0 when not windowed
+	size       int64        // size of the file in windowed mode
+	private    bool         // copy-on-write mapping, changes never reach the file
+	anon       bool         // private data was moved to anonymous memory
+	owned      bool         // Close closes fd
+	mapFlags   int          // extra mmap flags
+	advice     []int        // madvise advice applied to the mapping
+	prot       int          // protection override, -1 to derive it from flag
+	growth     GrowthPolicy // grows the capacity of the mapping
+	fileSize   int64        // size of the file on disk, bigger than Data until trimmed
+	syncMu     sync.Mutex   // serializes trimming by concurrent Sync calls
+	dirtyMu    sync.Mutex
+	dirty      []Range             // page aligned ranges changed since the last Sync
+	protect    []rangeAttr         // page aligned ranges with the protection set by Mprotect
+	locks      []rangeAttr         // page aligned ranges locked by Mlock with their flags
+	advised    map[int][]rangeAttr // page aligned ranges advised by MadviseRange, by the advice resetting them
+	hugePage   int64               // size of the hugetlb pages backing the mapping, 0 for normal pages
+	closed     bool                // set by Close, every later call fails with ErrClosed
+	leases     atomic.Int64        // number of outstanding leases
+	leaseDebug bool
+	leaseMu    sync.Mutex
+	leased     map[*Lease][]byte // stacks of the outstanding leases in debug mode
 }
 
 // Open opens or creates the named file as memory-mapped.
 func OpenFile(name string, flag int, perm uint32) (*Mmap, error) {
-	f, err := os.OpenFile(name, flag, os.FileMode(perm))
-	if err != nil {
-		return nil, err
-	}
-	m := new(Mmap)
-	m.fd = f
-	m.flag = flag
-	m.append = flag&os.O_APPEND != 0
-	stat, err := f.Stat()
-	if err != nil {
-		f.Close()
-		return nil, err
-	}
-	if stat.Size() > 0 {
-		err = m.mmap(stat.Size())
-		if err != nil {
-			f.Close()
-			return nil, err
-		}
-	}
-	return m, nil
+	return Open(name, WithFlag(flag), WithPerm(perm))
+}
+
+// OpenRange opens the named file and maps only length bytes of it starting at file offset off.
+// The offset does not need to be page aligned. Data holds the requested window, while ReadAt, WriteAt
+// and Seek take file offsets. Accesses outside the window are rejected.
+func OpenRange(name string, flag int, perm uint32, off, length int64) (*Mmap, error) {
+	return Open(name, WithFlag(flag), WithPerm(perm), WithRange(off, length))
+}
+
+// OpenPrivate opens the named file as a private copy-on-write memory mapping.
+// Changes are only visible to the calling process and never reach the file, even when the mapping
+// grows or shrinks. The file can be opened read-only while the mapping is still writable.
+func OpenPrivate(name string, flag int, perm uint32) (*Mmap, error) {
+	return Open(name, WithFlag(flag), WithPerm(perm), WithPrivate())
 }
 
 // Create creates the named file of specified size as memmory-mapped.
+// Files on hugetlbfs must have a size that is a multiple of the huge page size.
 func Create(name string, size int64, flag int, perm uint32) (*Mmap, error) {
-	f, err := os.OpenFile(name, flag, os.FileMode(perm))
-	if err != nil {
-		return nil, err
-	}
-	m := new(Mmap)
-	m.fd = f
-	m.flag = flag
-	err = m.mmap(size)
-	if err != nil {
-		f.Close()
-		return nil, err
-	}
-	return m, nil
+	return Open(name, WithFlag(flag), WithPerm(perm), WithSize(size))
 }
 
 // Close closes the memory-mapped file, rendering it unusable for I/O.
-func (m *Mmap) Close() (err error) {
+// Methods called after Close return an error wrapping os.ErrClosed, calling Close again does nothing.
+// Leases that are still outstanding are reported with an error wrapping ErrLeased.
+// A file passed to FromFile is left open unless the mapping owns it.
+func (m *Mmap) Close() error {
 	m.Lock()
 	defer m.Unlock()
-	if m.Data != nil {
-		addr := unsafe.Pointer(unsafe.SliceData(m.Data))
-		_, _, errno := syscall.Syscall(SYS_MUNMAP, uintptr(addr), uintptr(len(m.Data)), 0)
-		if errno != 0 {
-			err = fmt.Errorf("munmap: %s", errno.Error())
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	lerr := m.leaked()
+	terr := m.trim()
+	uerr := m.munmap()
+	var cerr error
+	if m.fd != nil && m.owned {
+		cerr = m.fd.Close()
+	}
+	return errors.Join(lerr, terr, uerr, cerr)
+}
+
+// Sync flushes changes made to a file that was mapped into memory using mmap back to the filesystem.
+// Only the pages changed by Write, WriteAt or reported by MarkDirty are flushed.
+// Sync does not block concurrent readers.
+func (m *Mmap) Sync() (err error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return m.wrap("sync", 0, 0, ErrClosed)
+	}
+	if m.private {
+		return nil
+	}
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	dirty := m.takeDirty()
+	if m.window > 0 {
+		// Changes in previous windows are only reachable through the file
+		err = m.fd.Sync()
+		if err != nil {
+			m.restoreDirty(dirty)
 		}
+		return err
 	}
-	err = m.fd.Close()
+	err = m.trim()
 	if err != nil {
+		m.restoreDirty(dirty)
 		return err
 	}
-	m = nil
+	base := m.start &^ (pageSize - 1)
+	mapped := (int64(len(m.mem)) + pageSize - 1) &^ (pageSize - 1)
+	fsync := false
+	for i, r := range dirty {
+		from, to := r.Off-base, r.Off-base+r.Len
+		if from < 0 || to > mapped {
+			// Changed before a Remap or Truncate moved the mapping away
+			fsync = true
+			continue
+		}
+		if to > int64(len(m.mem)) {
+			to = int64(len(m.mem))
+		}
+		err = msync(m.mem[from:to], MS_SYNC)
+		if err != nil {
+			m.restoreDirty(dirty[i:])
+			return m.wrap("msync", r.Off, r.Len, err)
+		}
+	}
+	if fsync {
+		err = m.fd.Sync()
+		if err != nil {
+			m.restoreDirty(dirty)
+		}
+	}
 	return err
 }
 
-// Sync flushes changes made to a file that was mapped into memory using mmap back to the filesystem.
-func (m *Mmap) Sync() (err error) {
-	m.Lock()
-	defer m.Unlock()
-	if m.Data == nil {
+// SyncRange flushes changes made to length bytes starting at file offset off back to the filesystem.
+// The range is extended to page boundaries. flags is MS_SYNC to wait for the write back or MS_ASYNC
+// to only schedule it, optionally combined with MS_INVALIDATE. SyncRange does not block concurrent readers.
+func (m *Mmap) SyncRange(off, length int64, flags int) error {
+	m.RLock()
+	defer m.RUnlock()
+	if m.closed {
+		return m.wrap("msync", off, length, ErrClosed)
+	}
+	if m.private {
 		return nil
 	}
-	addr := unsafe.Pointer(unsafe.SliceData(m.Data))
-	_, _, errno := syscall.Syscall(SYS_MSYNC, uintptr(addr), uintptr(len(m.Data)), uintptr(MS_SYNC))
-	if errno != 0 {
-		err = fmt.Errorf("msync: %s", errno.Error())
+	if m.window > 0 {
+		if flags&MS_SYNC != 0 {
+			return m.fd.Sync()
+		}
+		return nil
 	}
-	return err
+	start, end := m.bounds()
+	if length < 0 || off < start || off+length > end {
+		return m.wrap("msync", off, length, ErrOutOfRange)
+	}
+	if length == 0 {
+		return nil
+	}
+	base := m.start &^ (pageSize - 1)
+	from := (off - base) &^ (pageSize - 1)
+	err := msync(m.mem[from:off-base+length], flags)
+	if err != nil {
+		return m.wrap("msync", off, length, err)
+	}
+	return nil
 }
 
 // Read reads up to len(b) bytes from the File. It returns the number of bytes read and any error encountered.
@@ -113,13 +200,21 @@ func (m *Mmap) Sync() (err error) {
 func (m *Mmap) Read(b []byte) (n int, err error) {
 	m.Lock()
 	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("read", m.offset, int64(len(b)), ErrClosed)
+	}
+	if m.window > 0 {
+		n, err = m.windowRead(b, m.offset)
+		m.offset += int64(n)
+		return n, err
+	}
 	if m.Data == nil {
 		return 0, io.EOF
 	}
-	if m.offset >= int64(len(m.Data)) {
+	if m.offset >= m.start+int64(len(m.Data)) {
 		return 0, io.EOF
 	}
-	n, err = safeCopy(b, m.Data[m.offset:])
+	n, err = m.copyOut(b, m.offset)
 	if err == nil {
 		m.offset += int64(n)
 	}
@@ -129,34 +224,62 @@ func (m *Mmap) Read(b []byte) (n int, err error) {
 // ReadAt reads len(b) bytes from the File starting at byte offset off. It returns the number of bytes read and the error, if any.
 // ReadAt always returns a non-nil error when n < len(b). At end of file, that error is io.EOF.
 func (m *Mmap) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, m.wrap("read", off, int64(len(b)), ErrNegativePosition)
+	}
+	if m.window > 0 {
+		m.Lock()
+		defer m.Unlock()
+		if m.closed {
+			return 0, m.wrap("read", off, int64(len(b)), ErrClosed)
+		}
+		n, err = m.windowRead(b, off)
+		if err == nil && n < len(b) {
+			err = io.EOF
+		}
+		return n, err
+	}
 	m.RLock()
 	defer m.RUnlock()
+	if m.closed {
+		return 0, m.wrap("read", off, int64(len(b)), ErrClosed)
+	}
 	if m.Data == nil {
 		return 0, io.EOF
 	}
-	if off >= int64(len(m.Data)) {
+	if m.ranged && (off < m.start || off >= m.start+int64(len(m.Data))) {
+		return 0, m.wrap("read", off, int64(len(b)), ErrOutOfRange)
+	}
+	if off >= m.start+int64(len(m.Data)) {
 		return 0, io.EOF
 	}
-	n, err = safeCopy(b, m.Data[off:])
+	n, err = m.copyOut(b, off)
 	if err == nil && n < len(b) {
 		err = io.EOF
 	}
 	return n, err
 }
 
-// Size returns the size of the file.
+// Size returns the size of the file, or 0 after Close.
 func (m *Mmap) Size() int64 {
 	var size int64
 	m.RLock()
-	if m.Data != nil {
+	if m.closed {
+		size = 0
+	} else if m.window > 0 {
+		size = m.size
+	} else if m.Data != nil {
 		size = int64(len(m.Data))
 	}
 	m.RUnlock()
 	return size
 }
 
-// Name returns the name of the file as presented to Open.
+// Name returns the name of the file as presented to Open, empty for anonymous mappings.
 func (m *Mmap) Name() string {
+	if m.fd == nil {
+		return ""
+	}
 	return m.fd.Name()
 }
 
@@ -172,59 +295,83 @@ func (m *Mmap) Offset() int64 {
 // 0 means relative to the origin of the file,
 // 1 means relative to the current offset,
 // and 2 means relative to the end. It returns the new offset and an error, if any.
+// Seeking past the end of file is allowed, a following Write extends the file leaving a hole that reads as zeros.
 func (m *Mmap) Seek(offset int64, whence int) (int64, error) {
 	var abs int64
 	m.Lock()
 	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("seek", 0, 0, ErrClosed)
+	}
+	start, end := m.bounds()
 	switch whence {
 	case SEEK_SET:
 		abs = offset
 	case SEEK_CUR:
 		abs = m.offset + offset
 	case SEEK_END:
-		abs = int64(len(m.Data)) + offset
+		abs = end + offset
 	default:
-		return 0, errors.New("invalid whence value")
+		return 0, m.wrap("seek", 0, 0, ErrInvalidWhence)
 	}
 	if abs < 0 {
-		return 0, errors.New("negative position")
+		return 0, m.wrap("seek", 0, 0, ErrNegativePosition)
 	}
-	if abs > int64(len(m.Data)) {
-		return 0, errors.New("offset goes beyond the end of file")
+	if m.ranged && (abs < start || abs > end) {
+		return 0, m.wrap("seek", abs, 0, ErrOutOfRange)
 	}
 	m.offset = abs
 	return abs, nil
 }
 
 // Write writes len(b) bytes to the File. It returns the number of bytes written and an error, if any.
-// Write returns a non-nil error when n != len(b).
+// Write returns a non-nil error when n != len(b). Writing to a read-only mapping fails with ErrReadOnly.
 func (m *Mmap) Write(b []byte) (n int, err error) {
 	m.Lock()
-	if m.Data == nil {
-		err = m.mmap(int64(len(b)))
-		if err != nil {
-			m.Unlock()
-			return 0, err
-		}
-	} else {
-		if m.append {
+	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("write", m.offset, int64(len(b)), ErrClosed)
+	}
+	return m.write(b)
+}
+
+// Write at the current offset and advance it, the mapping must be locked
+func (m *Mmap) write(b []byte) (n int, err error) {
+	if m.append {
+		if m.window > 0 {
+			m.offset = m.size
+		} else {
 			m.offset = int64(len(m.Data))
 		}
-		if m.offset+int64(len(b)) > int64(len(m.Data)) {
-			err = m.mremap(int64(len(m.Data) + len(b)))
-			if err != nil {
-				m.Unlock()
-				return 0, err
-			}
+	}
+	if m.offset > math.MaxInt64-int64(len(b)) {
+		return 0, m.wrap("write", m.offset, int64(len(b)), ErrTooLarge)
+	}
+	if !m.writable(m.offset, int64(len(b))) {
+		return 0, m.wrap("write", m.offset, int64(len(b)), ErrReadOnly)
+	}
+	if m.window > 0 {
+		n, err = m.windowWrite(b, m.offset)
+		m.markDirty(m.offset, int64(n))
+		m.offset += int64(n)
+		return n, err
+	}
+	if m.ranged {
+		if m.offset+int64(len(b)) > m.start+int64(len(m.Data)) {
+			return 0, m.wrap("write", m.offset, int64(len(b)), ErrOutOfRange)
+		}
+	} else if m.offset+int64(len(b)) > int64(len(m.Data)) {
+		err = m.grow(m.offset + int64(len(b)))
+		if err != nil {
+			return 0, err
 		}
 	}
-	n, err = safeCopy(m.Data[m.offset:], b)
+	n, err = m.copyIn(m.offset, b)
+	m.markDirty(m.offset, int64(n))
 	if err != nil {
-		m.Unlock()
 		return n, err
 	}
 	m.offset += int64(n)
-	m.Unlock()
 	if n != len(b) {
 		err = io.ErrShortWrite
 	}
@@ -232,71 +379,353 @@ func (m *Mmap) Write(b []byte) (n int, err error) {
 }
 
 // WriteAt writes len(b) bytes to the File starting at byte offset off. It returns the number of bytes written and an error, if any.
-// WriteAt returns a non-nil error when n != len(b).
+// WriteAt returns a non-nil error when n != len(b). Writing past the end of file extends it to off+len(b).
 func (m *Mmap) WriteAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, m.wrap("write", off, int64(len(b)), ErrNegativePosition)
+	}
+	if off > math.MaxInt64-int64(len(b)) {
+		return 0, m.wrap("write", off, int64(len(b)), ErrTooLarge)
+	}
 	if m.append {
-		return 0, errors.New("invalid use of WriteAt on file opened with O_APPEND")
+		return 0, m.wrap("write", off, int64(len(b)), ErrAppendWriteAt)
 	}
 	m.Lock()
-	if m.Data == nil {
-		err = m.mmap(off + int64(len(b)))
-		if err != nil {
-			m.Unlock()
-			return 0, err
+	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("write", off, int64(len(b)), ErrClosed)
+	}
+	if !m.writable(off, int64(len(b))) {
+		return 0, m.wrap("write", off, int64(len(b)), ErrReadOnly)
+	}
+	if m.window > 0 {
+		n, err = m.windowWrite(b, off)
+		m.markDirty(off, int64(n))
+		return n, err
+	}
+	if m.ranged {
+		if off < m.start || off+int64(len(b)) > m.start+int64(len(m.Data)) {
+			return 0, m.wrap("write", off, int64(len(b)), ErrOutOfRange)
 		}
 	} else if off+int64(len(b)) > int64(len(m.Data)) {
-		err = m.mremap(int64(len(m.Data) + len(b)))
+		err = m.grow(off + int64(len(b)))
 		if err != nil {
-			m.Unlock()
 			return 0, err
 		}
 	}
-	n, err = safeCopy(m.Data[off:], b)
-	m.Unlock()
+	n, err = m.copyIn(off, b)
+	m.markDirty(off, int64(n))
 	if err == nil && n != len(b) {
 		err = io.ErrShortWrite
 	}
 	return n, err
 }
 
+// WriteTo writes the mapped bytes from the current offset to the end of file to w and advances the offset.
+// Shared mappings are sent with sendfile when w is an *os.File or a net.Conn backed by a file descriptor,
+// otherwise the mapped memory is handed to w.Write without copying it to an intermediate buffer.
+func (m *Mmap) WriteTo(w io.Writer) (n int64, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("read", m.offset, 0, ErrClosed)
+	}
+	_, end := m.bounds()
+	if m.offset >= end {
+		return 0, nil
+	}
+	n, err = m.writeTo(w, m.offset, end)
+	m.offset += n
+	return n, err
+}
+
+// ReadFrom reads from r until EOF and writes the data at the current offset, advancing it.
+// The mapping is grown ahead of the data, using the size of r when it is known, and r reads
+// straight into mapped memory. Ranged and windowed mappings copy through a buffer instead.
+func (m *Mmap) ReadFrom(r io.Reader) (n int64, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return 0, m.wrap("write", m.offset, 0, ErrClosed)
+	}
+	if m.ranged || m.window > 0 || len(m.protect) > 0 {
+		return m.readFromBuffer(r)
+	}
+	if m.protection()&PROT_WRITE == 0 {
+		return 0, m.wrap("write", m.offset, 0, ErrReadOnly)
+	}
+	if m.append {
+		m.offset = int64(len(m.Data))
+	}
+	hint := sizeHint(r)
+	for {
+		want := int64(readFromChunk)
+		if hint > want {
+			want = hint
+		}
+		hint = 0
+		size := int64(len(m.Data))
+		if m.offset+want > int64(len(m.mem)) || (!m.private && m.fileSize < m.offset+want) {
+			err = m.grow(m.offset + want)
+			if err != nil {
+				m.Data = m.mem[:size]
+				return n, err
+			}
+		}
+		var c int
+		var rerr error
+		ferr := guard(func() {
+			c, rerr = r.Read(m.mem[m.offset:])
+		})
+		if ferr != nil {
+			rerr = m.faultError(ferr, m.offset)
+		}
+		m.markDirty(m.offset, int64(c))
+		m.offset += int64(c)
+		n += int64(c)
+		if m.offset > size {
+			size = m.offset
+		}
+		m.Data = m.mem[:size]
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Copy r to the mapping through a buffer, for mappings that can not grow in place
+func (m *Mmap) readFromBuffer(r io.Reader) (n int64, err error) {
+	buf := make([]byte, readFromChunk)
+	for {
+		c, rerr := r.Read(buf)
+		if c > 0 {
+			c, err = m.write(buf[:c])
+			n += int64(c)
+			if err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
 // Truncate changes the size of the file. It does not change the I/O offset.
+// It fails with ErrLeased when the mapping has to move while leases are outstanding,
+// and with ErrInvalidRange when size is not a multiple of the hugetlb page size of the mapping.
 func (m *Mmap) Truncate(size int64) error {
 	m.Lock()
-	err := m.mremap(size)
-	m.Unlock()
-	return err
+	defer m.Unlock()
+	if m.closed {
+		return m.wrap("truncate", size, 0, ErrClosed)
+	}
+	if m.ranged {
+		return m.wrap("truncate", size, 0, ErrUnsupported)
+	}
+	if m.hugeAlign(size) != size {
+		return m.wrap("truncate", size, 0, ErrInvalidRange)
+	}
+	if m.window > 0 {
+		// The window is mapped again on the next access
+		err := m.munmap()
+		if err != nil {
+			return err
+		}
+		err = m.truncate(size)
+		if err != nil {
+			return err
+		}
+		m.size = size
+		return nil
+	}
+	return m.mremap(size)
+}
+
+// Remap replaces the current mapping with a window of length bytes starting at file offset off.
+// The offset does not need to be page aligned. The I/O offset is moved to off.
+// It fails with ErrLeased while leases are outstanding.
+func (m *Mmap) Remap(off, length int64) error {
+	if m.append {
+		return m.wrap("remap", off, length, ErrUnsupported)
+	}
+	if m.window > 0 || m.fd == nil {
+		return m.wrap("remap", off, length, ErrUnsupported)
+	}
+	if off < 0 || length <= 0 {
+		return m.wrap("remap", off, length, ErrInvalidRange)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return m.wrap("remap", off, length, ErrClosed)
+	}
+	err := m.checkLeases("remap", off, length)
+	if err != nil {
+		return err
+	}
+	// Ranged mappings never trim the file, drop the capacity it was grown to first
+	err = m.trim()
+	if err != nil {
+		return err
+	}
+	mem, ranged := m.mem, m.ranged
+	m.ranged = true
+	err = m.mmap(off, length)
+	if err != nil {
+		m.ranged = ranged
+		return err
+	}
+	m.offset = off
+	err = unmap(mem)
+	if err != nil {
+		return m.wrap("munmap", 0, int64(len(mem)), err)
+	}
+	return nil
+}
+
+// Protection returns the PROT_* page protection of the mapping, derived from the access mode of the
+// file unless it was set with WithProtection. Private mappings are always readable and writable.
+func (m *Mmap) Protection() int {
+	m.RLock()
+	prot := m.protection()
+	m.RUnlock()
+	return prot
+}
+
+// Return the page protection of the mapping
+func (m *Mmap) protection() int {
+	if m.prot >= 0 {
+		return m.prot
+	}
+	if m.private || m.flag&syscall.O_ACCMODE == os.O_RDWR {
+		// Copy-on-write pages are writable even when the file is not
+		return PROT_READ | PROT_WRITE
+	}
+	return PROT_READ
+}
+
+// Range returns the file offset and length of the mapped window.
+func (m *Mmap) Range() (off, length int64) {
+	m.RLock()
+	off, length = m.start, int64(len(m.Data))
+	m.RUnlock()
+	return off, length
+}
+
+// Return the range of file offsets that can be accessed
+func (m *Mmap) bounds() (start, end int64) {
+	if m.window > 0 {
+		return 0, m.size
+	}
+	return m.start, m.start + int64(len(m.Data))
+}
+
+// Check that n bytes at the page aligned file offset off are mapped and return the span of
+// pages that hold them, for operations on page ranges of mappings that are not windowed
+func (m *Mmap) pageSpan(op string, off, n int64) (from, to int64, err error) {
+	if m.closed {
+		return 0, 0, m.wrap(op, off, n, ErrClosed)
+	}
+	if m.window > 0 {
+		return 0, 0, m.wrap(op, off, n, ErrUnsupported)
+	}
+	if n < 0 || off&(pageSize-1) != 0 {
+		return 0, 0, m.wrap(op, off, n, ErrInvalidRange)
+	}
+	base := m.start &^ (pageSize - 1)
+	to = (off + n + pageSize - 1) &^ (pageSize - 1)
+	if off < base || to > base+((int64(len(m.mem))+pageSize-1)&^(pageSize-1)) {
+		return 0, 0, m.wrap(op, off, n, ErrOutOfRange)
+	}
+	return off, to, nil
+}
+
+// Return the memory of the mapping between the page aligned file offsets from and to
+func (m *Mmap) pages(from, to int64) []byte {
+	base := m.start &^ (pageSize - 1)
+	if to-base > int64(len(m.mem)) {
+		to = base + int64(len(m.mem))
+	}
+	return m.mem[from-base : to-base]
 }
 
 // Madvise advise the kernel about the expected behavior of the mapped pages.
 func (m *Mmap) Madvise(advice int) error {
 	m.RLock()
 	defer m.RUnlock()
-	if m.Data == nil {
+	if m.closed {
+		return m.wrap("madvise", 0, 0, ErrClosed)
+	}
+	if m.mem == nil {
 		return nil
 	}
-	addr := unsafe.Pointer(unsafe.SliceData(m.Data))
-	_, _, errno := syscall.Syscall(SYS_MADVISE, uintptr(addr), uintptr(len(m.Data)), uintptr(advice))
+	err := madvise(m.mem, advice)
+	if err != nil {
+		return m.wrap("madvise", m.start&^(pageSize-1), int64(len(m.mem)), err)
+	}
+	return nil
+}
+
+// Flush a memory region back to the filesystem
+func msync(mem []byte, flags int) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MSYNC, uintptr(addr), uintptr(len(mem)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Give advice about the use of a memory region
+func madvise(mem []byte, advice int) error {
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MADVISE, uintptr(addr), uintptr(len(mem)), uintptr(advice))
 	if errno != 0 {
-		return fmt.Errorf("madvise: %s", errno.Error())
+		return errno
 	}
 	return nil
 }
 
-// Map file to memory
-func (m *Mmap) mmap(size int64) error {
-	if size > maxSize {
-		return fmt.Errorf("mmap: requested size bigger than arch maxSize")
-	}
-	var protection int
-	mapping := MAP_SHARED
-	if m.flag&os.O_WRONLY != 0 {
-		protection = PROT_READ | PROT_WRITE
-	} else if m.flag&os.O_RDWR != 0 {
-		protection = PROT_READ | PROT_WRITE
-	} else {
-		protection = PROT_READ
-	}
-	if protection != PROT_READ {
+// Map length bytes of the file starting at offset off to memory
+func (m *Mmap) mmap(off, length int64) error {
+	base := off &^ (pageSize - 1)
+	size := off - base + length
+	if size > maxSize || uint64(base>>mmapOffsetShift) > uint64(^uintptr(0)) {
+		return m.wrap("mmap", off, length, ErrTooLarge)
+	}
+	protection := m.protection()
+	mapping := MAP_SHARED | m.mapFlags
+	if m.private {
+		mapping = MAP_PRIVATE | m.mapFlags
+	}
+	// Private mappings never change the file
+	writable := !m.private && m.flag&syscall.O_ACCMODE == os.O_RDWR
+	switch {
+	case m.window > 0:
+		// The window never goes past the end of file, callers grow the file first
+	case m.ranged:
+		// Never shrink the file underneath a window, only grow it when the window goes past its end
+		stat, err := m.fd.Stat()
+		if err != nil {
+			return err
+		}
+		if stat.Size() < off+length {
+			if !writable {
+				return m.wrap("mmap", off, length, ErrOutOfRange)
+			}
+			err = m.truncate(off + length)
+			if err != nil {
+				return err
+			}
+		}
+	case writable:
 		err := m.truncate(int64(size))
 		if err != nil {
 			return err
@@ -309,67 +738,276 @@ func (m *Mmap) mmap(size int64) error {
 		uintptr(protection),
 		uintptr(mapping),
 		m.fd.Fd(),
-		0,
+		uintptr(base>>mmapOffsetShift),
 	)
 	if errno != 0 {
-		return fmt.Errorf("mmap: %s", errno.Error())
+		return m.wrap("mmap", base, size, errno)
+	}
+	mem, data, start := m.mem, m.Data, m.start
+	protect, locks, advised := m.protect, m.locks, m.advised
+	m.mem = toSlice(mmapAddr, size)
+	m.Data = m.mem[off-base:]
+	m.start = off
+	err := m.setup(0)
+	if err != nil {
+		// Leave the mapping as it was
+		unmap(m.mem)
+		m.mem, m.Data, m.start = mem, data, start
+		m.protect, m.locks, m.advised = protect, locks, advised
+		return err
 	}
-	m.Data = unsafe.Slice((*byte)(unsafe.Pointer(mmapAddr)), size)
 	return nil
 }
 
+// Unmap the memory of the mapping
+func (m *Mmap) munmap() error {
+	err := unmap(m.mem)
+	if err != nil {
+		return m.wrap("munmap", m.start&^(pageSize-1), int64(len(m.mem)), err)
+	}
+	m.mem = nil
+	m.Data = nil
+	m.protect = nil
+	m.locks = nil
+	m.advised = nil
+	return nil
+}
+
+// Unmap a memory region returned by mmap
+func unmap(mem []byte) error {
+	if mem == nil {
+		return nil
+	}
+	addr := unsafe.Pointer(unsafe.SliceData(mem))
+	_, _, errno := syscall.Syscall(SYS_MUNMAP, uintptr(addr), uintptr(len(mem)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Grow the size of Data, growing the capacity of the mapping according to the growth policy
+func (m *Mmap) grow(size int64) error {
+	if size > int64(len(m.mem)) {
+		if size > maxSize {
+			return m.wrap("mremap", 0, size, ErrTooLarge)
+		}
+		growth := m.growth
+		if growth == nil {
+			growth = GrowGeometric
+		}
+		capacity := growth(int64(len(m.mem)), size)
+		if capacity < size {
+			capacity = size
+		}
+		err := m.mremap(m.hugeAlign(capacity))
+		if err != nil {
+			return err
+		}
+	} else if !m.private && m.fileSize < int64(len(m.mem)) {
+		// The file was trimmed, extend it again to cover the mapping
+		err := m.truncate(int64(len(m.mem)))
+		if err != nil {
+			return err
+		}
+	}
+	m.Data = m.mem[:size]
+	return nil
+}
+
+// Trim the file back to the size of Data
+func (m *Mmap) trim() error {
+	// Files on hugetlbfs can only be trimmed to whole huge pages
+	size := m.hugeAlign(int64(len(m.Data)))
+	if m.private || m.ranged || m.window > 0 || m.fileSize <= size {
+		return nil
+	}
+	return m.truncate(size)
+}
+
 // Use mremap to increase the size of allocated memory
 func (m *Mmap) mremap(size int64) error {
 	if size > maxSize {
-		return fmt.Errorf("mmap: requested size bigger than arch maxSize")
+		return m.wrap("mremap", 0, size, ErrTooLarge)
+	}
+	err := m.checkLeases("mremap", 0, size)
+	if err != nil {
+		return err
 	}
-	addr := unsafe.Pointer(unsafe.SliceData(m.Data))
 	if size == 0 {
-		_, _, errno := syscall.Syscall(SYS_MUNMAP, uintptr(addr), uintptr(len(m.Data)), 0)
-		if errno != 0 {
-			err := fmt.Errorf("munmap: %s", errno.Error())
+		err = m.munmap()
+		if err != nil || m.private {
 			return err
 		}
-		m.Data = nil
 		return m.truncate(size)
 	}
-	err := m.truncate(size)
+	if m.private && (!m.anon || m.mem == nil) && size > int64(len(m.mem)) {
+		return m.anonGrow(size)
+	}
+	if m.mem == nil {
+		return m.mmap(0, size)
+	}
+	if m.hugePage > 0 {
+		return m.hugeRemap(size)
+	}
+	if !m.private {
+		err = m.truncate(size)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.unprotect()
+	if err != nil {
+		return err
+	}
+	err = m.unlock()
 	if err != nil {
 		return err
 	}
+	err = m.unadvise()
+	if err != nil {
+		return err
+	}
+	addr := unsafe.Pointer(unsafe.SliceData(m.mem))
 	mmapAddr, _, errno := syscall.Syscall6(
 		SYS_MREMAP,
 		uintptr(addr),
-		uintptr(len(m.Data)),
+		uintptr(len(m.mem)),
 		uintptr(size),
 		uintptr(MREMAP_MAYMOVE),
 		0,
 		0,
 	)
 	if errno != 0 {
-		return fmt.Errorf("mremap: %v", errno.Error())
+		m.reprotect()
+		m.relock()
+		m.readvise()
+		return m.wrap("mremap", 0, size, errno)
+	}
+	old := int64(len(m.mem))
+	m.mem = toSlice(mmapAddr, size)
+	m.Data = m.mem
+	return m.setup(old)
+}
+
+// Apply the mapping options to the memory of the mapping, after it was mapped or grown from
+// the old size. The kernel does not carry populated or locked pages over to the grown part.
+func (m *Mmap) setup(old int64) error {
+	if m.mem == nil {
+		return nil
+	}
+	for _, advice := range m.advice {
+		err := madvise(m.mem, advice)
+		if err != nil {
+			return m.wrap("madvise", m.start&^(pageSize-1), int64(len(m.mem)), err)
+		}
+	}
+	err := m.readvise()
+	if err != nil {
+		return err
+	}
+	err = m.reprotect()
+	if err != nil {
+		return err
+	}
+	err = m.relock()
+	if err != nil {
+		return err
+	}
+	if old == 0 || old >= int64(len(m.mem)) {
+		return nil
+	}
+	grown := m.mem[old:]
+	if m.mapFlags&MAP_LOCKED != 0 {
+		err = mlock(grown, 0)
+		if err != nil {
+			return m.wrap("mlock", old, int64(len(grown)), err)
+		}
+	}
+	if m.mapFlags&MAP_POPULATE != 0 {
+		// Like MAP_POPULATE, break copy-on-write of writable private mappings
+		advice := MADV_POPULATE_READ
+		if m.private && m.protection()&PROT_WRITE != 0 {
+			advice = MADV_POPULATE_WRITE
+		}
+		err := madvise(grown, advice)
+		if err == syscall.EINVAL {
+			// Kernels before 5.14 can only be hinted
+			err = madvise(grown, MADV_WILLNEED)
+		}
+		if err != nil {
+			return m.wrap("madvise", old, int64(len(grown)), err)
+		}
 	}
-	m.Data = unsafe.Slice((*byte)(unsafe.Pointer(mmapAddr)), size)
 	return nil
 }
 
+// Move private data to anonymous memory of the given size,
+// private file mappings can not grow past the end of the file without touching it.
+func (m *Mmap) anonGrow(size int64) error {
+	mem, err := mmapAnon(size, m.mapFlags)
+	if err != nil {
+		return m.wrap("mmap", 0, size, err)
+	}
+	// Pages protected by Mprotect may not be readable
+	err = m.unprotect()
+	if err != nil {
+		unmap(mem)
+		return err
+	}
+	_, err = m.copyOut(mem, 0)
+	if err != nil {
+		m.reprotect()
+		unmap(mem)
+		return err
+	}
+	old := int64(len(m.mem))
+	// Keep the ranges set on the old memory, setup applies them to the new one
+	protect, locks, advised := m.protect, m.locks, m.advised
+	err = m.munmap()
+	if err != nil {
+		unmap(mem)
+		return err
+	}
+	m.protect, m.locks, m.advised = protect, locks, advised
+	m.mem = mem
+	m.Data = mem
+	m.anon = true
+	return m.setup(old)
+}
+
+// Map anonymous private memory
+func mmapAnon(size int64, flags int) ([]byte, error) {
+	if size > maxSize {
+		return nil, ErrTooLarge
+	}
+	mmapAddr, _, errno := syscall.Syscall6(
+		SYS_MMAP,
+		0,
+		uintptr(size),
+		uintptr(PROT_READ|PROT_WRITE),
+		uintptr(MAP_PRIVATE|MAP_ANONYMOUS|flags),
+		^uintptr(0),
+		0,
+	)
+	if errno != 0 {
+		return nil, errno
+	}
+	return toSlice(mmapAddr, size), nil
+}
+
 // Truncate the file
 func (m *Mmap) truncate(length int64) error {
-	_, _, errno := syscall.Syscall(SYS_FTRUNCATE, uintptr(m.fd.Fd()), uintptr(length), 0)
-	if errno != 0 {
-		return fmt.Errorf("ftrunicate: %v", errno.Error())
+	// syscall.Ftruncate passes 64bit lengths correctly on 32bit CPUs
+	err := syscall.Ftruncate(int(m.fd.Fd()), length)
+	if err != nil {
+		return m.wrap("ftruncate", length, 0, err)
 	}
+	m.fileSize = length
 	return nil
 }
 
-// Safely copy data without panicking on bus errors.
-func safeCopy(s, d []byte) (n int, err error) {
-	debug.SetPanicOnFault(true)
-	defer func() {
-		if e := recover(); e != nil {
-			err = fmt.Errorf("bus error: %s", e)
-		}
-	}()
-	n = copy(s, d)
-	return n, err
+// Convert an address returned by the kernel to a byte slice of the given size
+func toSlice(addr uintptr, size int64) []byte {
+	return unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr))), size)
 }
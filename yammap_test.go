@@ -13,6 +13,8 @@ package yammap
 
 import (
 	"bytes"
+	"errors"
+	"math"
 	"math/rand"
 	"os"
 	"strconv"
@@ -104,6 +106,103 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestClose(t *testing.T) {
+	name, err := rndfile(os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Error("second Close failed", err)
+	}
+	if m.Size() != 0 {
+		t.Error("wrong size after Close")
+	}
+	b := make([]byte, 10)
+	_, err = m.Read(b)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for Read after Close", err)
+	}
+	_, err = m.ReadAt(b, 0)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for ReadAt after Close", err)
+	}
+	_, err = m.Write(b)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for Write after Close", err)
+	}
+	_, err = m.WriteAt(b, 0)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for WriteAt after Close", err)
+	}
+	_, err = m.Seek(0, SEEK_SET)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for Seek after Close", err)
+	}
+	err = m.Truncate(0)
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for Truncate after Close", err)
+	}
+	err = m.Sync()
+	if !errors.Is(err, os.ErrClosed) {
+		t.Error("wrong error for Sync after Close", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	name, err := rndfile(os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Protection() != PROT_READ {
+		t.Error("wrong protection of read-only mapping", m.Protection())
+	}
+	_, err = m.Write([]byte("test"))
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("wrong error writing to a read-only mapping", err)
+	}
+	_, err = m.WriteAt([]byte("test"), 0)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Error("wrong error writing to a read-only mapping", err)
+	}
+	w, err := OpenFile(name, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Protection() != PROT_READ|PROT_WRITE {
+		t.Error("wrong protection of write-only mapping", w.Protection())
+	}
+	_, err = w.WriteAt([]byte("test"), 0)
+	if err != nil {
+		t.Error("failed to write to a write-only mapping", err)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = FromFile(f)
+	if !errors.Is(err, ErrWriteOnly) {
+		t.Error("wrong error mapping a write-only file", err)
+	}
+}
+
 func TestMadvise(t *testing.T) {
 	name := tmpname()
 	m, err := Create(name, int64(os.Getpagesize()), os.O_RDWR|os.O_CREATE, 0644)
@@ -196,6 +295,386 @@ func TestTruncateToZero(t *testing.T) {
 	}
 }
 
+func TestOpenRange(t *testing.T) {
+	size := os.Getpagesize() * 4
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := int64(os.Getpagesize() + 100)
+	length := int64(os.Getpagesize())
+	m, err := OpenRange(name, os.O_RDWR, 0644, off, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !bytes.Equal(m.Data, data[off:off+length]) {
+		t.Error("wrong data in mapped window")
+	}
+	b := make([]byte, 10)
+	_, err = m.ReadAt(b, off+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data[off+10:off+20]) {
+		t.Error("wrong data read")
+	}
+	_, err = m.ReadAt(b, off-1)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to read before the mapped window")
+	}
+	_, err = m.ReadAt(b, off+length)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to read after the mapped window")
+	}
+	msg := rndmessage(10)
+	_, err = m.WriteAt(msg, off+length-5)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to write after the mapped window")
+	}
+	_, err = m.WriteAt(msg, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset() != off {
+		t.Error("wrong initial offset")
+	}
+	_, err = m.Seek(-10, SEEK_END)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset() != off+length-10 {
+		t.Error("wrong offset")
+	}
+	_, err = m.Seek(0, SEEK_SET)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to seek before the mapped window")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, data[off:off+10]) {
+		t.Error("wrong data written")
+	}
+	if int64(len(data)) != int64(size) {
+		t.Error("file size changed")
+	}
+}
+
+func TestRemap(t *testing.T) {
+	size := os.Getpagesize() * 4
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	off := int64(3*os.Getpagesize() - 7)
+	err = m.Remap(off, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, length := m.Range()
+	if start != off || length != 100 {
+		t.Error("wrong mapped range")
+	}
+	b := make([]byte, 100)
+	n, err := m.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(b) || !bytes.Equal(b, data[off:off+100]) {
+		t.Error("wrong data read")
+	}
+	err = m.Remap(int64(size-10), 100)
+	if err == nil {
+		t.Error("allowed to map a read-only range beyond the end of file")
+	}
+}
+
+func TestRemapTrim(t *testing.T) {
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	_, err = m.Write(rndmessage(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Remap(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 200 {
+		t.Error("file not trimmed to the logical size before remapping", f.Size())
+	}
+}
+
+func TestRemapFailure(t *testing.T) {
+	size := os.Getpagesize() * 4
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	m, err := OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	data := append([]byte(nil), m.Data...)
+	// Invalid advice makes the setup of the new mapping fail
+	m.advice = []int{-1}
+	err = m.Remap(int64(os.Getpagesize()), 100)
+	m.advice = nil
+	if err == nil {
+		t.Fatal("remapped with invalid advice")
+	}
+	start, length := m.Range()
+	if start != 0 || length != int64(size) || !bytes.Equal(m.Data, data) {
+		t.Error("failed remap changed the mapping", start, length)
+	}
+	err = m.Remap(int64(os.Getpagesize()), 100)
+	if err != nil {
+		t.Error("failed to remap after a failed remap", err)
+	}
+}
+
+func TestPrivate(t *testing.T) {
+	size := os.Getpagesize() * 2
+	name, err := rndfile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenPrivate(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	msg := rndmessage(os.Getpagesize())
+	_, err = m.WriteAt(msg, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Seek(0, SEEK_END)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != int64(size+len(msg)) {
+		t.Error("wrong size after growing private mapping")
+	}
+	if !bytes.Equal(m.Data[100:100+len(msg)], msg) || !bytes.Equal(m.Data[size:], msg) {
+		t.Error("wrong data in private mapping")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Truncate(int64(os.Getpagesize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Error("private mapping changed the file")
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	msg := rndmessage(200)
+	for i := 0; i < 100; i++ {
+		_, err = m.Write(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := int64(100 * len(msg))
+	if m.Size() != size {
+		t.Error("wrong logical size after appending")
+	}
+	if int64(len(m.mem)) <= size {
+		t.Error("capacity did not grow geometrically")
+	}
+	err = m.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != size {
+		t.Error("file not trimmed to the logical size on sync")
+	}
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(b)) != size+int64(len(msg)) {
+		t.Error("file not trimmed to the logical size on close")
+	}
+	if !bytes.Equal(b[size:], msg) {
+		t.Error("wrong data after growing a trimmed file")
+	}
+}
+
+func TestGrowExact(t *testing.T) {
+	name := tmpname()
+	m, err := Open(name, WithFlag(os.O_RDWR|os.O_CREATE), WithGrowth(GrowExact))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	msg := rndmessage(200)
+	for i := 0; i < 10; i++ {
+		_, err = m.Write(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.mem) != len(m.Data) {
+			t.Fatal("capacity differs from the size with exact growth")
+		}
+	}
+}
+
+func TestGrowTooLarge(t *testing.T) {
+	for _, needed := range []int64{maxSize - 1, maxSize, maxSize + 1, 1<<62 + 1, math.MaxInt64} {
+		c := GrowGeometric(pageSize, needed)
+		if c < needed || (needed <= maxSize && c > maxSize) {
+			t.Error("wrong geometric capacity", needed, c)
+		}
+	}
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	_, err = m.WriteAt([]byte("x"), 1<<62+1)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Error("allowed to grow past the largest mapping", err)
+	}
+	_, err = m.Seek(1<<62, SEEK_SET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Write([]byte("x"))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Error("allowed to grow past the largest mapping", err)
+	}
+}
+
+func TestSyncRange(t *testing.T) {
+	name := tmpname()
+	size := int64(4 * os.Getpagesize())
+	m, err := Create(name, size, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	msg := rndmessage(os.Getpagesize())
+	off := int64(os.Getpagesize() + 100)
+	_, err = m.WriteAt(msg, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SyncRange(off, int64(len(msg)), MS_SYNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SyncRange(off, int64(len(msg)), MS_ASYNC|MS_INVALIDATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[off:off+int64(len(msg))], msg) {
+		t.Error("wrong data after syncing range")
+	}
+	err = m.SyncRange(size-10, 20, MS_SYNC)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("allowed to sync past the end of the mapping")
+	}
+	done := make(chan error)
+	m.RLock()
+	go func() {
+		done <- m.Sync()
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("sync blocked by a concurrent reader")
+	}
+	m.RUnlock()
+}
+
 func TestName(t *testing.T) {
 	name := tmpname()
 	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
@@ -242,15 +721,94 @@ func TestSeek(t *testing.T) {
 		t.Error("wrong offset")
 	}
 	_, err = m.Seek(1024, SEEK_END)
-	if err.Error() != "offset goes beyond the end of file" {
-		t.Error("allowed to seek beyond the end of file")
+	if err != nil {
+		t.Error("not allowed to seek beyond the end of file")
+	}
+	if m.Size()+1024 != m.Offset() {
+		t.Error("wrong offset")
 	}
 	_, err = m.Seek(-1024, SEEK_SET)
-	if err.Error() != "negative position" {
+	if !errors.Is(err, ErrNegativePosition) {
 		t.Error("allowed to seek with negative position")
 	}
 }
 
+func TestSparse(t *testing.T) {
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	osname := tmpname() + "_os"
+	f, err := os.OpenFile(osname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(osname)
+	msg := rndmessage(100)
+	hole := int64(3*os.Getpagesize() + 10)
+
+	off, err := m.Seek(hole, SEEK_END)
+	if err != nil {
+		t.Fatal(err)
+	}
+	osoff, err := f.Seek(hole, SEEK_END)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != osoff {
+		t.Error("seek past the end of file differs from os.File")
+	}
+	b := make([]byte, 10)
+	n, err := m.Read(b)
+	osn, oserr := f.Read(b)
+	if n != osn || err != oserr {
+		t.Error("read past the end of file differs from os.File")
+	}
+	_, err = m.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.WriteAt(msg, 2*hole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(msg, 2*hole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != stat.Size() {
+		t.Error("size after sparse writes differs from os.File")
+	}
+	data := make([]byte, m.Size())
+	_, err = m.ReadAt(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	osdata := make([]byte, stat.Size())
+	_, err = f.ReadAt(osdata, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, osdata) {
+		t.Error("data after sparse writes differs from os.File")
+	}
+	if !bytes.Equal(data[:hole], make([]byte, hole)) {
+		t.Error("hole does not read as zeros")
+	}
+}
+
 func TestReadWrite(t *testing.T) {
 	name := tmpname()
 	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
@@ -317,6 +875,26 @@ func TestReadAtWriteAt(t *testing.T) {
 	if n != len(msg) {
 		t.Error("wrong number of bytes written")
 	}
+	_, err = m.WriteAt([]byte(msg), -1)
+	if !errors.Is(err, ErrNegativePosition) {
+		t.Error("allowed to write at a negative offset", err)
+	}
+	_, err = m.ReadAt(make([]byte, 10), -1)
+	if !errors.Is(err, ErrNegativePosition) {
+		t.Error("allowed to read at a negative offset", err)
+	}
+	_, err = m.WriteAt([]byte(msg), math.MaxInt64)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Error("allowed to write past the largest offset", err)
+	}
+	_, err = m.Seek(math.MaxInt64-1, SEEK_SET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Write([]byte(msg))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Error("allowed to write past the largest offset", err)
+	}
 	err = m.Sync()
 	if err != nil {
 		t.Fatal(err)
@@ -349,7 +927,7 @@ func TestReadAtWriteAt(t *testing.T) {
 		t.Fatal(err)
 	}
 	_, err = m3.WriteAt([]byte(msg), offset)
-	if err.Error() != "invalid use of WriteAt on file opened with O_APPEND" {
+	if !errors.Is(err, ErrAppendWriteAt) {
 		t.Error("allowed to write at offset in append mode")
 	}
 }
@@ -508,6 +1086,54 @@ func BenchmarkOSWrite(b *testing.B) {
 	}
 }
 
+func BenchmarkAppend(b *testing.B) {
+	name := tmpname()
+	m, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	data := rndmessage(200)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Write(data)
+	}
+}
+
+func BenchmarkAppendExact(b *testing.B) {
+	name := tmpname()
+	m, err := Open(name, WithFlag(os.O_RDWR|os.O_CREATE), WithGrowth(GrowExact))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	defer os.Remove(name)
+	data := rndmessage(200)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Write(data)
+	}
+}
+
+func BenchmarkOSAppend(b *testing.B) {
+	name := tmpname()
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(name)
+	data := rndmessage(200)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Write(data)
+	}
+}
+
 func BenchmarkRead(b *testing.B) {
 	testSize := os.Getpagesize() * 1024
 	name, err := rndfile(testSize)